@@ -6,11 +6,15 @@ import (
 	"fmt"
 	"go-data-flow/internal/config"
 	"go-data-flow/pkg/command"
+	"go-data-flow/pkg/election"
 	"go-data-flow/pkg/flow"
 	"go-data-flow/pkg/redis"
+	"go-data-flow/pkg/stream"
 	"go-data-flow/pkg/util"
 	"net/http"
-	"time"
+	"os"
+	"os/signal"
+	"syscall"
 
 	"github.com/gin-gonic/gin"
 	"github.com/rs/zerolog/log"
@@ -21,35 +25,48 @@ var syncCmd = &cobra.Command{
 	Use:   "flow",
 	Short: "",
 	Run: func(cmd *cobra.Command, args []string) {
-		for i := 0; i < 10; i++ {
-			time.Sleep(time.Second * 10)
-			ok, err := redis.Ins.SetNX(config.Cfg.DaemonKey, 1, 10*time.Second).Result()
-			if err != nil {
-				log.Err(err).Msgf(fmt.Sprintf("set redis nx %s error:%s", config.Cfg.DaemonKey, err))
-				continue
-			}
-			if !ok {
-				err := fmt.Errorf("%s process exist", config.Cfg.DaemonKey)
-				log.Err(err).Msgf(fmt.Sprintf("set redis nx %s error:%s", config.Cfg.DaemonKey, err))
-				continue
-			}
-			break
+		elector, err := election.NewElector(config.Cfg.DaemonKey, config.Cfg.Election, config.Cfg.LockFile, redis.Ins != nil)
+		if err != nil {
+			log.Err(err).Msg("create elector failed")
+			return
 		}
-
-		defer func() {
-			redis.Ins.Del(config.Cfg.DaemonKey)
+		gs := util.NewGracefulShutdown(context.Background())
+		go func() {
+			// 竞选期间也能响应退出信号
+			sigCh := make(chan os.Signal, 1)
+			signal.Notify(sigCh, syscall.SIGINT, syscall.SIGTERM)
+			defer signal.Stop(sigCh)
+			select {
+			case <-sigCh:
+				gs.Cancel()
+			case <-gs.Context().Done():
+			}
 		}()
+		// 备用实例在这里等待，直到 leader 失去租约后接管
+		lease, err := elector.Campaign(gs.Context())
+		if err != nil {
+			log.Err(err).Msgf("%s campaign canceled", config.Cfg.DaemonKey)
+			return
+		}
+		defer elector.Resign()
 		go func() {
-			ticker := time.Tick(5 * time.Second)
-			for range ticker {
-				redis.Ins.Set(config.Cfg.DaemonKey, 1, 10*time.Second)
+			// 失去租约后停止所有流程，由其他实例接管，位置提交会被防护令牌拒绝
+			select {
+			case <-lease.Lost():
+				log.Error().Err(lease.Err()).Msgf("%s leadership lost, shutdown", config.Cfg.DaemonKey)
+				gs.Cancel()
+			case <-gs.Context().Done():
 			}
 		}()
+		ctx := stream.WithFence(gs.Context(), lease)
 
-		gs := util.NewGracefulShutdown(context.Background())
 		errc := make(chan error)
 		go func() {
 			for err := range errc {
+				log.Err(err).Msg("flow error")
+				if config.Cfg.Mail.Server.Host == "" {
+					continue
+				}
 				util.MailTo(config.Cfg.Mail.Server, fmt.Sprintf("%s:%s", config.Cfg.DaemonKey, "Data Flow Error Alert"), err.Error(), config.Cfg.Mail.To, nil)
 			}
 		}()
@@ -63,7 +80,7 @@ var syncCmd = &cobra.Command{
 				return
 			}
 			go func() {
-				fitem.Run(gs.Context(), errc)
+				fitem.Run(ctx, errc)
 			}()
 		}
 
@@ -1,17 +1,23 @@
 package config
 
 import (
+	"fmt"
+	"go-data-flow/pkg/election"
 	"go-data-flow/pkg/flow"
 	"go-data-flow/pkg/logs"
 	"go-data-flow/pkg/redis"
 	"go-data-flow/pkg/util"
 	"io/ioutil"
+	"os"
+	"path/filepath"
 
 	"gopkg.in/yaml.v2"
 )
 
 type Config struct {
 	DaemonKey string            `yaml:"daemon_key"`
+	LockFile  string            `yaml:"lock_file"` // 使用文件锁选主时的锁文件
+	Election  election.Config   `yaml:"election"`
 	Flows     []flow.Config     `yaml:"flows"`
 	Redis     redis.RedisConfig `yaml:"redis"`
 	Http      HttpConfig        `yaml:"http"`
@@ -37,7 +43,18 @@ func LoadConfig(fpath string) error {
 	if Cfg.DaemonKey == "" {
 		Cfg.DaemonKey = "go-data-flow-daemon"
 	}
+	if Cfg.LockFile == "" {
+		Cfg.LockFile = filepath.Join(os.TempDir(), Cfg.DaemonKey+".lock")
+	}
+	for idx := range Cfg.Flows {
+		if Cfg.Flows[idx].Name == "" {
+			Cfg.Flows[idx].Name = fmt.Sprintf("flow%d", idx)
+		}
+	}
 	logs.Init(Cfg.Log)
-	util.IfErrPanic(redis.Init(Cfg.Redis))
+	// redis 可选，未配置时以单机模式运行
+	if Cfg.Redis.Addr != "" {
+		util.IfErrPanic(redis.Init(Cfg.Redis))
+	}
 	return nil
 }
@@ -0,0 +1,45 @@
+package deadletter
+
+import (
+	"context"
+	"errors"
+	"go-data-flow/pkg/output"
+	"go-data-flow/pkg/stream"
+	"go-data-flow/pkg/util"
+)
+
+// Config 死信队列配置，只能配置一种。
+// file、kafka 可以通过命令重放，output 可以是任意已注册的输出，只用于存档不能重放
+type Config struct {
+	File   *FileConfig    `yaml:"file"`
+	Kafka  *KafkaConfig   `yaml:"kafka"`
+	Output *output.Config `yaml:"output"`
+}
+
+// Queue 死信队列
+type Queue interface {
+	stream.DeadLetterQueue
+	// Replay 分批取出死信交给 fn 处理，fn 返回后才确认该批次。
+	// fn 返回错误时停止，未确认的死信下次重放时继续
+	Replay(ctx context.Context, fn func([]stream.DeadLetter) error) (int, error)
+}
+
+// replayBatchSize 重放时每批的死信数量
+const replayBatchSize = 100
+
+var ErrNotReplayable = errors.New("dead letter queue is not replayable")
+
+func New(cancelable *util.Cancelable, cfg *Config) (Queue, error) {
+	switch {
+	case cfg == nil:
+		return nil, nil
+	case cfg.File != nil:
+		return newFileQueue(cfg.File)
+	case cfg.Kafka != nil:
+		return newKafkaQueue(cfg.Kafka)
+	case cfg.Output != nil:
+		return newOutputQueue(cancelable, cfg.Output)
+	default:
+		return nil, errors.New("dead letter must have file, kafka or output setting")
+	}
+}
@@ -0,0 +1,115 @@
+package deadletter
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"go-data-flow/pkg/stream"
+)
+
+// FileConfig 死信按 JSON 行追加到文件
+type FileConfig struct {
+	Path string `yaml:"path"`
+}
+
+type fileQueue struct {
+	path string
+	mu   sync.Mutex
+}
+
+func newFileQueue(cfg *FileConfig) (*fileQueue, error) {
+	if cfg.Path == "" {
+		return nil, errors.New("file dead letter must have path setting")
+	}
+	if err := os.MkdirAll(filepath.Dir(cfg.Path), 0755); err != nil {
+		return nil, err
+	}
+	return &fileQueue{path: cfg.Path}, nil
+}
+
+func (q *fileQueue) Write(ctx context.Context, letters ...stream.DeadLetter) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	file, err := os.OpenFile(q.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	w := bufio.NewWriter(file)
+	for _, letter := range letters {
+		raw, err := json.Marshal(letter)
+		if err != nil {
+			return err
+		}
+		w.Write(raw)
+		w.WriteByte('\n')
+	}
+	if err = w.Flush(); err != nil {
+		return err
+	}
+	return file.Sync()
+}
+
+// Replay 先将当前文件移到 .replaying，重放期间新的死信写入新文件，全部成功后删除。
+// 中途失败时 .replaying 只保留未确认的死信
+func (q *fileQueue) Replay(ctx context.Context, fn func([]stream.DeadLetter) error) (int, error) {
+	replaying := q.path + ".replaying"
+	q.mu.Lock()
+	_, err := os.Stat(replaying)
+	if os.IsNotExist(err) {
+		err = os.Rename(q.path, replaying)
+	}
+	q.mu.Unlock()
+	if os.IsNotExist(err) {
+		return 0, nil
+	} else if err != nil {
+		return 0, err
+	}
+
+	raw, err := os.ReadFile(replaying)
+	if err != nil {
+		return 0, err
+	}
+	lines := bytes.Split(bytes.TrimSpace(raw), []byte("\n"))
+	count := 0
+	for len(lines) > 0 && len(lines[0]) > 0 {
+		size := min(replayBatchSize, len(lines))
+		err = ctx.Err()
+		letters := make([]stream.DeadLetter, size)
+		for idx := 0; idx < size && err == nil; idx++ {
+			if err = json.Unmarshal(lines[idx], &letters[idx]); err != nil {
+				err = fmt.Errorf("invalid dead letter %s: %w", lines[idx], err)
+			}
+		}
+		if err == nil {
+			err = fn(letters)
+		}
+		if err != nil {
+			if count > 0 {
+				writeErr := writeFile(replaying, append(bytes.Join(lines, []byte("\n")), '\n'))
+				if writeErr != nil {
+					return count, fmt.Errorf("%w, save unreplayed dead letters failed: %s", err, writeErr)
+				}
+			}
+			return count, err
+		}
+		count += size
+		lines = lines[size:]
+	}
+	return count, os.Remove(replaying)
+}
+
+func writeFile(path string, data []byte) error {
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
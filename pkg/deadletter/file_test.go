@@ -0,0 +1,51 @@
+package deadletter
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+
+	"go-data-flow/pkg/stream"
+
+	"github.com/longbridgeapp/assert"
+)
+
+func TestFileQueueReplay(t *testing.T) {
+	ctx := context.Background()
+	queue, err := newFileQueue(&FileConfig{Path: filepath.Join(t.TempDir(), "dlq", "flow.jsonl")})
+	assert.NoError(t, err)
+
+	event := &stream.Event{Topic: "127.0.0.1:3306", Datas: []map[string]interface{}{{"id": 1}}}
+	var letters []stream.DeadLetter
+	for i := 0; i < replayBatchSize+1; i++ {
+		letters = append(letters, stream.NewDeadLetter(event, "output.elastic", errors.New("write failed")))
+	}
+	assert.NoError(t, queue.Write(ctx, letters...))
+
+	// 第二批失败，只保留未确认的死信
+	batches := 0
+	count, err := queue.Replay(ctx, func(letters []stream.DeadLetter) error {
+		batches++
+		if batches == 2 {
+			return errors.New("output down")
+		}
+		assert.Equal(t, "output.elastic", letters[0].Stage)
+		return nil
+	})
+	assert.Error(t, err)
+	assert.Equal(t, replayBatchSize, count)
+
+	count, err = queue.Replay(ctx, func(letters []stream.DeadLetter) error {
+		replayed, err := letters[0].Event(ctx)
+		assert.NoError(t, err)
+		assert.Equal(t, 1, replayed.Retries)
+		return nil
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, 1, count)
+
+	count, err = queue.Replay(ctx, func(letters []stream.DeadLetter) error { return nil })
+	assert.NoError(t, err)
+	assert.Equal(t, 0, count)
+}
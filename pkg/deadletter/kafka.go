@@ -0,0 +1,122 @@
+package deadletter
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"go-data-flow/pkg/kafkaclient"
+	"go-data-flow/pkg/stream"
+
+	"github.com/segmentio/kafka-go"
+)
+
+// KafkaConfig 死信写入 kafka topic，重放时使用单独的消费组从上次重放的位置继续
+type KafkaConfig struct {
+	kafkaclient.Config `yaml:",inline"`
+	Topic              string `yaml:"topic"`
+	Group              string `yaml:"group"` // 重放使用的消费组，默认为 topic-replay
+}
+
+// replayIdle 重放时超过该时间没有新消息视为已经取完
+const replayIdle = 5 * time.Second
+
+type kafkaQueue struct {
+	cfg    *KafkaConfig
+	dialer *kafka.Dialer
+	writer *kafka.Writer
+}
+
+func newKafkaQueue(cfg *KafkaConfig) (*kafkaQueue, error) {
+	if cfg.Topic == "" || len(cfg.Brokers) == 0 {
+		return nil, errors.New("kafka dead letter must have brokers and topic setting")
+	}
+	if cfg.Group == "" {
+		cfg.Group = cfg.Topic + "-replay"
+	}
+	dialer, err := cfg.Dialer()
+	if err != nil {
+		return nil, err
+	}
+	transport, err := cfg.Transport()
+	if err != nil {
+		return nil, err
+	}
+	writer := &kafka.Writer{
+		Addr:         kafka.TCP(cfg.Brokers...),
+		Topic:        cfg.Topic,
+		Balancer:     &kafka.Hash{},
+		RequiredAcks: kafka.RequireAll,
+		Transport:    transport,
+	}
+	return &kafkaQueue{cfg: cfg, dialer: dialer, writer: writer}, nil
+}
+
+func (q *kafkaQueue) Write(ctx context.Context, letters ...stream.DeadLetter) error {
+	msgs := make([]kafka.Message, len(letters))
+	for idx, letter := range letters {
+		raw, err := json.Marshal(letter)
+		if err != nil {
+			return err
+		}
+		msgs[idx] = kafka.Message{Key: []byte(letter.Key), Value: raw}
+	}
+	return q.writer.WriteMessages(ctx, msgs...)
+}
+
+func (q *kafkaQueue) Replay(ctx context.Context, fn func([]stream.DeadLetter) error) (int, error) {
+	reader := kafka.NewReader(kafka.ReaderConfig{
+		Brokers:     q.cfg.Brokers,
+		Topic:       q.cfg.Topic,
+		GroupID:     q.cfg.Group,
+		StartOffset: kafka.FirstOffset,
+		Dialer:      q.dialer,
+	})
+	defer reader.Close()
+	// 重放中再次失败的死信会写回同一个 topic，只重放开始之前的死信
+	start := time.Now()
+	count := 0
+	for {
+		msgs, more, err := fetchBatch(ctx, reader, start)
+		if err != nil || len(msgs) == 0 {
+			return count, err
+		}
+		letters := make([]stream.DeadLetter, len(msgs))
+		for idx, msg := range msgs {
+			if err = json.Unmarshal(msg.Value, &letters[idx]); err != nil {
+				return count, fmt.Errorf("invalid dead letter at %s[%d]@%d: %w", msg.Topic, msg.Partition, msg.Offset, err)
+			}
+		}
+		if err = fn(letters); err != nil {
+			return count, err
+		}
+		if err = reader.CommitMessages(ctx, msgs...); err != nil {
+			return count, err
+		}
+		count += len(msgs)
+		if !more {
+			return count, nil
+		}
+	}
+}
+
+// fetchBatch 取一批 before 之前写入的消息，超过 replayIdle 没有新消息或遇到之后写入的消息时返回已取到的部分
+func fetchBatch(ctx context.Context, reader *kafka.Reader, before time.Time) (msgs []kafka.Message, more bool, err error) {
+	for len(msgs) < replayBatchSize {
+		fetchCtx, cancel := context.WithTimeout(ctx, replayIdle)
+		msg, err := reader.FetchMessage(fetchCtx)
+		cancel()
+		if errors.Is(err, context.DeadlineExceeded) && ctx.Err() == nil {
+			return msgs, false, nil
+		} else if err != nil {
+			return nil, false, err
+		}
+		if msg.Time.After(before) {
+			return msgs, false, nil
+		}
+		msgs = append(msgs, msg)
+	}
+	return msgs, true, nil
+}
@@ -0,0 +1,46 @@
+package deadletter
+
+import (
+	"context"
+	"encoding/json"
+
+	"go-data-flow/pkg/handler"
+	"go-data-flow/pkg/output"
+	"go-data-flow/pkg/stream"
+	"go-data-flow/pkg/util"
+)
+
+// outputQueue 将死信作为事件写入已注册的输出，每条死信为一条数据
+type outputQueue struct {
+	output handler.Handler
+}
+
+func newOutputQueue(cancelable *util.Cancelable, cfg *output.Config) (*outputQueue, error) {
+	out, err := output.Factory(cancelable, *cfg, nil)
+	if err != nil {
+		return nil, err
+	}
+	return &outputQueue{output: out}, nil
+}
+
+func (q *outputQueue) Write(ctx context.Context, letters ...stream.DeadLetter) error {
+	datas := make([]map[string]interface{}, len(letters))
+	for idx, letter := range letters {
+		raw, err := json.Marshal(letter)
+		if err != nil {
+			return err
+		}
+		if err = json.Unmarshal(raw, &datas[idx]); err != nil {
+			return err
+		}
+	}
+	event := stream.Event{Context: ctx, Topic: "dead_letter", Datas: datas, Ack: stream.NewAck()}
+	err := q.output.OnEvent(ctx, &event)
+	event.Ack.Done(err)
+	// 等待缓冲型输出真正写入
+	return event.Ack.Wait(ctx)
+}
+
+func (q *outputQueue) Replay(ctx context.Context, fn func([]stream.DeadLetter) error) (int, error) {
+	return 0, ErrNotReplayable
+}
@@ -0,0 +1,216 @@
+package election
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"go-data-flow/pkg/logs"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+)
+
+var ErrLeaseLost = errors.New("leader lease lost")
+
+// Config 选主配置，type 可选 redis、mysql、file，
+// 未配置时有 redis 配置使用 redis，否则使用本地文件锁（只能保证单机单实例）
+type Config struct {
+	Type     string      `yaml:"type"`
+	TTLSec   int         `yaml:"ttl_sec"`   // 租约有效期，默认 10 秒，每 1/3 有效期续约一次
+	RetrySec int         `yaml:"retry_sec"` // 备用实例竞选间隔，默认 5 秒
+	MySQL    MySQLConfig `yaml:"mysql"`
+}
+
+// locker 租约的具体实现，token 为每次加锁获得的单调递增的防护令牌
+type locker interface {
+	acquire(ctx context.Context) (token int64, ok bool, err error)
+	renew(ctx context.Context, token int64) (bool, error)
+	release(token int64) error
+}
+
+// Elector 基于租约的选主，未成为 leader 的实例持续竞选，leader 失去租约后自动接管
+type Elector struct {
+	locker locker
+	ttl    time.Duration
+	retry  time.Duration
+	logger zerolog.Logger
+
+	mu    sync.Mutex
+	lease *Lease
+}
+
+// NewElector key 为锁名，同一 key 的实例中只有一个能成为 leader
+func NewElector(key string, cfg Config, lockFile string, hasRedis bool) (*Elector, error) {
+	typ := cfg.Type
+	if typ == "" {
+		typ = "file"
+		if hasRedis {
+			typ = "redis"
+		}
+	}
+	if cfg.TTLSec <= 0 {
+		cfg.TTLSec = 10
+	}
+	if cfg.RetrySec <= 0 {
+		cfg.RetrySec = 5
+	}
+	ttl := time.Duration(cfg.TTLSec) * time.Second
+	var (
+		l   locker
+		err error
+	)
+	switch typ {
+	case "redis":
+		l, err = newRedisLocker(key, ttl)
+	case "mysql":
+		l, err = newMySQLLocker(key, cfg.MySQL)
+	case "file":
+		l = newFileLocker(lockFile)
+	default:
+		err = fmt.Errorf("unknown election type %s", typ)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &Elector{
+		locker: l,
+		ttl:    ttl,
+		retry:  time.Duration(cfg.RetrySec) * time.Second,
+		logger: log.With().Any(logs.Election, key).Str("type", typ).Logger(),
+	}, nil
+}
+
+// Campaign 阻塞直到成为 leader 或 ctx 取消，成为 leader 后在后台持续续约
+func (e *Elector) Campaign(ctx context.Context) (*Lease, error) {
+	for {
+		token, ok, err := e.locker.acquire(ctx)
+		if err != nil {
+			e.logger.Error().Err(err).Msg("campaign failed")
+		} else if ok {
+			lease := newLease(token, e.ttl)
+			e.mu.Lock()
+			e.lease = lease
+			e.mu.Unlock()
+			e.logger.Info().Int64("token", token).Msg("became leader")
+			go e.keepalive(ctx, lease)
+			return lease, nil
+		} else {
+			e.logger.Info().Msg("standby, leader exists")
+		}
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(e.retry):
+		}
+	}
+}
+
+// Resign 主动释放租约，备用实例可以立即接管
+func (e *Elector) Resign() error {
+	e.mu.Lock()
+	lease := e.lease
+	e.lease = nil
+	e.mu.Unlock()
+	if lease == nil {
+		return nil
+	}
+	lease.lose(errors.New("resigned"))
+	return e.locker.release(lease.token)
+}
+
+// keepalive 定期续约，续约被拒绝或在有效期内一直无法续约时视为失去租约
+func (e *Elector) keepalive(ctx context.Context, lease *Lease) {
+	ticker := time.NewTicker(e.ttl / 3)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-lease.Lost():
+			return
+		case <-ticker.C:
+		}
+		start := time.Now()
+		ok, err := e.locker.renew(ctx, lease.token)
+		switch {
+		case err != nil:
+			e.logger.Error().Err(err).Int64("token", lease.token).Msg("renew lease failed")
+			if lease.expired() {
+				lease.lose(fmt.Errorf("%w: %s", ErrLeaseLost, err))
+			}
+		case !ok:
+			lease.lose(fmt.Errorf("%w: token %d is not the owner", ErrLeaseLost, lease.token))
+		default:
+			// 以发起续约的时间计算有效期，避免网络延迟导致本地认为的有效期比实际长
+			lease.renewed(start)
+		}
+		if err := lease.Err(); err != nil {
+			e.logger.Error().Err(err).Int64("token", lease.token).Msg("leadership lost")
+			return
+		}
+	}
+}
+
+// Lease leader 租约，实现 stream.Fence，提交位置前检查租约仍然有效
+type Lease struct {
+	token    int64
+	ttl      time.Duration
+	mu       sync.Mutex
+	deadline time.Time
+	err      error
+	lost     chan struct{}
+}
+
+func newLease(token int64, ttl time.Duration) *Lease {
+	return &Lease{token: token, ttl: ttl, deadline: time.Now().Add(ttl), lost: make(chan struct{})}
+}
+
+func (l *Lease) Token() int64 {
+	return l.token
+}
+
+// Check 已失去租约或超过有效期未能续约时返回错误
+func (l *Lease) Check() error {
+	if err := l.Err(); err != nil {
+		return err
+	}
+	if l.expired() {
+		return fmt.Errorf("%w: token %d expired", ErrLeaseLost, l.token)
+	}
+	return nil
+}
+
+// Lost 失去租约时关闭
+func (l *Lease) Lost() <-chan struct{} {
+	return l.lost
+}
+
+func (l *Lease) Err() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.err
+}
+
+func (l *Lease) expired() bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return !time.Now().Before(l.deadline)
+}
+
+func (l *Lease) renewed(at time.Time) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.deadline = at.Add(l.ttl)
+}
+
+func (l *Lease) lose(err error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.err != nil {
+		return
+	}
+	l.err = err
+	close(l.lost)
+}
@@ -0,0 +1,92 @@
+package election
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/longbridgeapp/assert"
+	"github.com/rs/zerolog"
+)
+
+func testElector(l locker, ttl time.Duration) *Elector {
+	return &Elector{locker: l, ttl: ttl, retry: 10 * time.Millisecond, logger: zerolog.Nop()}
+}
+
+func TestFileElection(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	path := filepath.Join(t.TempDir(), "flow.lock")
+	leader := testElector(newFileLocker(path), 300*time.Millisecond)
+	standby := testElector(newFileLocker(path), 300*time.Millisecond)
+
+	lease, err := leader.Campaign(ctx)
+	assert.NoError(t, err)
+	assert.NoError(t, lease.Check())
+
+	// leader 持有锁时备用实例一直竞选不到
+	waitCtx, waitCancel := context.WithTimeout(ctx, 100*time.Millisecond)
+	defer waitCancel()
+	_, err = standby.Campaign(waitCtx)
+	assert.Equal(t, context.DeadlineExceeded, err)
+
+	// 续约后超过有效期仍然持有租约
+	time.Sleep(500 * time.Millisecond)
+	assert.NoError(t, lease.Check())
+
+	// 释放后备用实例接管，令牌比旧 leader 大
+	assert.NoError(t, leader.Resign())
+	assert.Error(t, lease.Check())
+	next, err := standby.Campaign(ctx)
+	assert.NoError(t, err)
+	assert.True(t, next.Token() > lease.Token())
+	assert.NoError(t, standby.Resign())
+}
+
+// testLocker 续约结果可控的租约
+type testLocker struct {
+	renewOK  bool
+	renewErr error
+}
+
+func (l *testLocker) acquire(ctx context.Context) (int64, bool, error) {
+	return 1, true, nil
+}
+
+func (l *testLocker) renew(ctx context.Context, token int64) (bool, error) {
+	return l.renewOK, l.renewErr
+}
+
+func (l *testLocker) release(token int64) error {
+	return nil
+}
+
+func TestLeaseExpiry(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// 续约一直出错，超过有效期后失去租约
+	elector := testElector(&testLocker{renewErr: errors.New("connection refused")}, 150*time.Millisecond)
+	lease, err := elector.Campaign(ctx)
+	assert.NoError(t, err)
+	assert.NoError(t, lease.Check())
+	select {
+	case <-lease.Lost():
+	case <-time.After(time.Second):
+		t.Fatal("lease not lost after ttl")
+	}
+	assert.True(t, errors.Is(lease.Check(), ErrLeaseLost))
+
+	// 续约被拒绝说明锁已被其他实例持有，立即失去租约
+	elector = testElector(&testLocker{}, 150*time.Millisecond)
+	lease, err = elector.Campaign(ctx)
+	assert.NoError(t, err)
+	select {
+	case <-lease.Lost():
+	case <-time.After(time.Second):
+		t.Fatal("lease not lost after renew rejected")
+	}
+	assert.True(t, errors.Is(lease.Err(), ErrLeaseLost))
+}
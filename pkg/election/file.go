@@ -0,0 +1,33 @@
+package election
+
+import (
+	"context"
+	"go-data-flow/pkg/util"
+	"time"
+)
+
+// fileLocker 本地文件锁，只能保证同一台机器上单实例运行。
+// 文件锁在进程退出前一直有效，续约总是成功，以加锁时间作为防护令牌
+type fileLocker struct {
+	lock *util.FileLock
+}
+
+func newFileLocker(path string) *fileLocker {
+	return &fileLocker{lock: util.NewFileLock(path)}
+}
+
+func (l *fileLocker) acquire(ctx context.Context) (int64, bool, error) {
+	ok, err := l.lock.TryLock()
+	if err != nil || !ok {
+		return 0, false, err
+	}
+	return time.Now().UnixNano(), true, nil
+}
+
+func (l *fileLocker) renew(ctx context.Context, token int64) (bool, error) {
+	return true, nil
+}
+
+func (l *fileLocker) release(token int64) error {
+	return l.lock.Unlock()
+}
@@ -0,0 +1,109 @@
+package election
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+
+	_ "github.com/go-sql-driver/mysql"
+)
+
+type MySQLConfig struct {
+	DSN string `yaml:"dsn"`
+}
+
+// mysqlLocker 使用 GET_LOCK 选主，锁随持有连接存在，连接断开后由 MySQL 自动释放。
+// 连接 ID 在 MySQL 重启后从头开始，不能作为防护令牌，令牌由 lease_tokens 表中的计数器生成
+type mysqlLocker struct {
+	db     *sql.DB
+	name   string
+	conn   *sql.Conn
+	connID int64 // 持有锁的连接 ID，续约时确认锁仍属于这个连接
+}
+
+const createTokenTable = "CREATE TABLE IF NOT EXISTS lease_tokens (name VARCHAR(64) NOT NULL PRIMARY KEY, token BIGINT NOT NULL)"
+
+// nextToken 持有锁时递增计数器，LAST_INSERT_ID(expr) 使新令牌作为本次写入的 ID 返回
+const nextToken = "INSERT INTO lease_tokens (name, token) VALUES (?, LAST_INSERT_ID(1)) ON DUPLICATE KEY UPDATE token = LAST_INSERT_ID(token + 1)"
+
+func newMySQLLocker(key string, cfg MySQLConfig) (*mysqlLocker, error) {
+	if cfg.DSN == "" {
+		return nil, errors.New("mysql election requires dsn setting")
+	}
+	db, err := sql.Open("mysql", cfg.DSN)
+	if err != nil {
+		return nil, err
+	}
+	// GET_LOCK 的锁名最长 64 个字符
+	if len(key) > 64 {
+		key = key[:64]
+	}
+	return &mysqlLocker{db: db, name: key}, nil
+}
+
+func (l *mysqlLocker) acquire(ctx context.Context) (int64, bool, error) {
+	conn, err := l.db.Conn(ctx)
+	if err != nil {
+		return 0, false, err
+	}
+	var (
+		locked sql.NullInt64
+		id     int64
+	)
+	if err = conn.QueryRowContext(ctx, "SELECT GET_LOCK(?, 0), CONNECTION_ID()", l.name).Scan(&locked, &id); err != nil {
+		conn.Close()
+		return 0, false, err
+	}
+	if locked.Int64 != 1 {
+		conn.Close()
+		return 0, false, nil
+	}
+	token, err := l.nextToken(ctx, conn)
+	if err != nil {
+		// 关闭连接同时释放锁
+		conn.Close()
+		return 0, false, err
+	}
+	l.conn, l.connID = conn, id
+	return token, true, nil
+}
+
+func (l *mysqlLocker) nextToken(ctx context.Context, conn *sql.Conn) (int64, error) {
+	if _, err := conn.ExecContext(ctx, createTokenTable); err != nil {
+		return 0, fmt.Errorf("create lease_tokens table failed: %w", err)
+	}
+	result, err := conn.ExecContext(ctx, nextToken, l.name)
+	if err != nil {
+		return 0, fmt.Errorf("increase lease token failed: %w", err)
+	}
+	return result.LastInsertId()
+}
+
+// renew 在持有锁的连接上确认锁仍属于自己，同时保持连接活跃
+func (l *mysqlLocker) renew(ctx context.Context, token int64) (bool, error) {
+	if l.conn == nil {
+		return false, nil
+	}
+	var owner sql.NullInt64
+	if err := l.conn.QueryRowContext(ctx, "SELECT IS_USED_LOCK(?)", l.name).Scan(&owner); err != nil {
+		// 持有锁的连接已断开，锁已被 MySQL 释放
+		if errors.Is(err, sql.ErrConnDone) {
+			return false, nil
+		}
+		return false, err
+	}
+	return owner.Valid && owner.Int64 == l.connID, nil
+}
+
+func (l *mysqlLocker) release(token int64) error {
+	if l.conn == nil {
+		return nil
+	}
+	defer func() {
+		l.conn.Close()
+		l.conn = nil
+	}()
+	_, err := l.conn.ExecContext(context.Background(), "DO RELEASE_LOCK(?)", l.name)
+	return err
+}
@@ -0,0 +1,165 @@
+package election
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"io"
+	"sync"
+	"testing"
+
+	"github.com/longbridgeapp/assert"
+)
+
+// testMySQL 模拟 GET_LOCK 和 lease_tokens 表，restart 后连接 ID 从头开始，表中的数据保留
+type testMySQL struct {
+	mu     sync.Mutex
+	connID int64
+	owner  int64
+	tokens map[string]int64
+}
+
+func (m *testMySQL) restart() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.connID, m.owner = 0, 0
+}
+
+func (m *testMySQL) Driver() driver.Driver {
+	return nil
+}
+
+func (m *testMySQL) Connect(ctx context.Context) (driver.Conn, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.connID++
+	return &testMySQLConn{server: m, id: m.connID}, nil
+}
+
+type testMySQLConn struct {
+	server *testMySQL
+	id     int64
+}
+
+func (c *testMySQLConn) Prepare(query string) (driver.Stmt, error) {
+	return nil, driver.ErrSkip
+}
+
+func (c *testMySQLConn) Begin() (driver.Tx, error) {
+	return nil, errors.New("not supported")
+}
+
+// Close 连接断开时 MySQL 释放连接持有的锁
+func (c *testMySQLConn) Close() error {
+	c.server.mu.Lock()
+	defer c.server.mu.Unlock()
+	if c.server.owner == c.id {
+		c.server.owner = 0
+	}
+	return nil
+}
+
+func (c *testMySQLConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	m := c.server
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	switch query {
+	case "SELECT GET_LOCK(?, 0), CONNECTION_ID()":
+		if m.owner != 0 && m.owner != c.id {
+			return &testRows{values: []driver.Value{int64(0), c.id}}, nil
+		}
+		m.owner = c.id
+		return &testRows{values: []driver.Value{int64(1), c.id}}, nil
+	case "SELECT IS_USED_LOCK(?)":
+		if m.owner == 0 {
+			return &testRows{values: []driver.Value{nil}}, nil
+		}
+		return &testRows{values: []driver.Value{m.owner}}, nil
+	}
+	return nil, errors.New("unexpected query " + query)
+}
+
+func (c *testMySQLConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	m := c.server
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	switch query {
+	case createTokenTable:
+		return driver.RowsAffected(0), nil
+	case nextToken:
+		name := args[0].Value.(string)
+		m.tokens[name]++
+		return testResult(m.tokens[name]), nil
+	case "DO RELEASE_LOCK(?)":
+		if m.owner == c.id {
+			m.owner = 0
+		}
+		return driver.RowsAffected(0), nil
+	}
+	return nil, errors.New("unexpected exec " + query)
+}
+
+type testResult int64
+
+func (r testResult) LastInsertId() (int64, error) { return int64(r), nil }
+func (r testResult) RowsAffected() (int64, error) { return 1, nil }
+
+type testRows struct {
+	values []driver.Value
+	read   bool
+}
+
+func (r *testRows) Columns() []string {
+	return make([]string, len(r.values))
+}
+
+func (r *testRows) Close() error { return nil }
+
+func (r *testRows) Next(dest []driver.Value) error {
+	if r.read {
+		return io.EOF
+	}
+	r.read = true
+	copy(dest, r.values)
+	return nil
+}
+
+func TestMySQLLockerToken(t *testing.T) {
+	server := &testMySQL{tokens: map[string]int64{}}
+	db := sql.OpenDB(server)
+	defer db.Close()
+	// 不复用连接，重启后的新连接使用新的连接 ID
+	db.SetMaxIdleConns(0)
+	ctx := context.Background()
+	leader := &mysqlLocker{db: db, name: "flow"}
+	standby := &mysqlLocker{db: db, name: "flow"}
+
+	first, ok, err := leader.acquire(ctx)
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	_, ok, err = standby.acquire(ctx)
+	assert.NoError(t, err)
+	assert.False(t, ok)
+	ok, err = leader.renew(ctx, first)
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	assert.NoError(t, leader.release(first))
+
+	second, ok, err := standby.acquire(ctx)
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	assert.True(t, second > first)
+
+	// MySQL 重启后连接 ID 从头开始，令牌仍然递增
+	server.restart()
+	third, ok, err := leader.acquire(ctx)
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	assert.True(t, third > second)
+	assert.Equal(t, int64(1), leader.connID)
+	ok, err = leader.renew(ctx, third)
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	assert.NoError(t, leader.release(third))
+}
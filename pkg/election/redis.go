@@ -0,0 +1,70 @@
+package election
+
+import (
+	"context"
+	"errors"
+	rds "go-data-flow/pkg/redis"
+	"strconv"
+	"time"
+
+	"github.com/go-redis/redis"
+)
+
+// 锁不存在时递增防护令牌并以令牌作为锁的值，返回令牌，锁已存在返回 0
+var acquireScript = redis.NewScript(`
+if redis.call('EXISTS', KEYS[1]) == 1 then
+	return 0
+end
+local token = redis.call('INCR', KEYS[2])
+redis.call('SET', KEYS[1], token, 'PX', ARGV[1])
+return token
+`)
+
+// 只有锁的值仍是自己的令牌时才续约，防止覆盖其他实例的锁
+var renewScript = redis.NewScript(`
+if redis.call('GET', KEYS[1]) == ARGV[1] then
+	return redis.call('PEXPIRE', KEYS[1], ARGV[2])
+end
+return 0
+`)
+
+var releaseScript = redis.NewScript(`
+if redis.call('GET', KEYS[1]) == ARGV[1] then
+	return redis.call('DEL', KEYS[1])
+end
+return 0
+`)
+
+type redisLocker struct {
+	rdb      *redis.Client
+	key      string
+	tokenKey string
+	ttlMs    int64
+}
+
+func newRedisLocker(key string, ttl time.Duration) (*redisLocker, error) {
+	if rds.Ins == nil {
+		return nil, errors.New("redis election requires redis setting")
+	}
+	return &redisLocker{rdb: rds.Ins, key: key, tokenKey: key + ":fencing_token", ttlMs: ttl.Milliseconds()}, nil
+}
+
+func (l *redisLocker) acquire(ctx context.Context) (int64, bool, error) {
+	token, err := acquireScript.Run(l.rdb, []string{l.key, l.tokenKey}, l.ttlMs).Int64()
+	if err != nil {
+		return 0, false, err
+	}
+	return token, token > 0, nil
+}
+
+func (l *redisLocker) renew(ctx context.Context, token int64) (bool, error) {
+	ret, err := renewScript.Run(l.rdb, []string{l.key}, strconv.FormatInt(token, 10), l.ttlMs).Int64()
+	if err != nil {
+		return false, err
+	}
+	return ret == 1, nil
+}
+
+func (l *redisLocker) release(token int64) error {
+	return releaseScript.Run(l.rdb, []string{l.key}, strconv.FormatInt(token, 10)).Err()
+}
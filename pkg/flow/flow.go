@@ -2,33 +2,60 @@ package flow
 
 import (
 	"context"
+	"fmt"
 	"go-data-flow/pkg/command"
+	"go-data-flow/pkg/deadletter"
 	"go-data-flow/pkg/handler"
 	"go-data-flow/pkg/input"
 	"go-data-flow/pkg/output"
 	"go-data-flow/pkg/stream"
 	"go-data-flow/pkg/util"
+	"hash/fnv"
+	"slices"
 	"sync"
+
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
 )
 
 type Config struct {
-	Input     input.Config    `yaml:"input"`
-	Outputs   []output.Config `yaml:"outputs"`
-	WorkCount int             `yaml:"work_count"`
+	Name       string             `yaml:"name"` // 流程名称，用于命令中指定流程，默认为 flow+序号
+	Input      input.Config       `yaml:"input"`
+	Outputs    []output.Config    `yaml:"outputs"`
+	WorkCount  int                `yaml:"work_count"`
+	DeadLetter *deadletter.Config `yaml:"dead_letter"` // 处理失败的事件转入死信队列，未配置时失败的事件由输入端重新同步
 }
 
 type Flow struct {
-	input  input.Input
-	output handler.Handler
-	worker int
+	name       string
+	input      input.Input
+	output     handler.Handler
+	stages     map[string]output.Output // 按死信阶段索引的输出
+	worker     int
+	deadLetter deadletter.Queue
+	cancelable *util.Cancelable
+	replaying  int32
+	logger     zerolog.Logger
 }
 
 func NewFlow(cancelable *util.Cancelable, cfg Config, commander *command.Commander) (*Flow, error) {
-	outputs, err := output.Outputs(cancelable, cfg.Outputs)
+	if err := validateTransactional(cfg); err != nil {
+		return nil, err
+	}
+	deadLetter, err := deadletter.New(cancelable, cfg.DeadLetter)
+	if err != nil {
+		return nil, err
+	}
+	// 未配置时传入 nil 接口，输入输出据此判断是否启用死信队列
+	var dlq stream.DeadLetterQueue
+	if deadLetter != nil {
+		dlq = deadLetter
+	}
+	outputs, stages, err := output.Outputs(cancelable, cfg.Outputs, dlq)
 	if err != nil {
 		return nil, err
 	}
-	input, err := input.NewInput(cancelable, cfg.Input, commander)
+	input, err := input.NewInput(cancelable, cfg.Input, commander, dlq)
 	if err != nil {
 		return nil, err
 	}
@@ -36,35 +63,100 @@ func NewFlow(cancelable *util.Cancelable, cfg Config, commander *command.Command
 	if worker == 0 {
 		worker = 1
 	}
-	return &Flow{
-		input:  input,
-		output: outputs,
-		worker: worker,
-	}, nil
+	f := &Flow{
+		name:       cfg.Name,
+		input:      input,
+		output:     outputs,
+		stages:     stages,
+		worker:     worker,
+		deadLetter: deadLetter,
+		cancelable: cancelable,
+		logger:     log.With().Str("flow", cfg.Name).Logger(),
+	}
+	commander.RegisterHandler("dead_letter", "replay", f.replayDeadLetter)
+	return f, nil
+}
+
+// validateTransactional 事务型 kafka 输出在输入的事务中写入，使用的是输入的连接，
+// 输出配置的 brokers 与输入不同时消息会写到输入所在的集群，直接报错
+func validateTransactional(cfg Config) error {
+	in := cfg.Input.Kafka
+	if in == nil || in.TransactionalID == "" {
+		return nil
+	}
+	inBrokers := slices.Sorted(slices.Values(in.Brokers))
+	for _, out := range cfg.Outputs {
+		if out.Kafka == nil || !out.Kafka.Transactional {
+			continue
+		}
+		outBrokers := slices.Sorted(slices.Values(out.Kafka.Brokers))
+		if !slices.Equal(inBrokers, outBrokers) {
+			return fmt.Errorf("transactional kafka output brokers %v must be the same as the input brokers %v", out.Kafka.Brokers, in.Brokers)
+		}
+	}
+	return nil
 }
 
+// Run 将输入事件按分区键分发到各个 worker，同一个键的事件总是由同一个 worker 顺序处理，
+// 不同键之间并行。没有分区键的事件轮询分发
 func (f *Flow) Run(ctx context.Context, errc chan error) {
+	flow := f.input.Flow(ctx)
+	queues := make([]chan stream.Event, f.worker)
 	var wg sync.WaitGroup
-	for i := 0; i < f.worker; i++ {
+	for i := range queues {
+		queues[i] = make(chan stream.Event, workerQueueSize)
 		wg.Add(1)
-		go func() {
+		go func(queue chan stream.Event) {
 			defer wg.Done()
-			flow := f.input.Flow(ctx)
-			for {
-				select {
-				case <-ctx.Done():
-					return
-				case err := <-flow.Err:
+			for event := range queue {
+				err := f.output.OnEvent(ctx, &event)
+				// 释放流程持有的确认，缓冲型输出会在真正写入后再确认
+				event.Ack.Done(err)
+				if err != nil {
 					errc <- err
-				case event, ok := <-flow.In:
-					if !ok {
-						return
-					}
-					flow.Out <- stream.EventResult{Result: 0, Error: f.output.OnEvent(ctx, &event)}
 				}
 			}
-		}()
+		}(queues[i])
+	}
+	defer func() {
+		for _, queue := range queues {
+			close(queue)
+		}
+		wg.Wait()
+	}()
+
+	next := 0
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case err := <-flow.Err:
+			errc <- err
+		case event, ok := <-flow.In:
+			if !ok {
+				return
+			}
+			idx := next % f.worker
+			if event.Key != "" {
+				idx = partition(event.Key, f.worker)
+			} else {
+				next++
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case queues[idx] <- event:
+			}
+			// 处理结果通过 Ack 通知输入端，这里只表示事件已被接收
+			flow.Out <- stream.EventResult{Result: 0}
+		}
 	}
-	wg.Wait()
-	return
+}
+
+const workerQueueSize = 64
+
+func partition(key string, n int) int {
+	h := fnv.New32a()
+	h.Write([]byte(key))
+	return int(h.Sum32() % uint32(n))
 }
@@ -0,0 +1,53 @@
+package flow
+
+import (
+	"fmt"
+	"testing"
+
+	"go-data-flow/pkg/input"
+	"go-data-flow/pkg/kafkaclient"
+	"go-data-flow/pkg/output"
+
+	"github.com/longbridgeapp/assert"
+)
+
+func TestValidateTransactional(t *testing.T) {
+	in := &input.KafkaInputConfig{Config: kafkaclient.Config{Brokers: []string{"k1:9092", "k2:9092"}}, TransactionalID: "txn-1"}
+	out := &output.KafkaOutputConfig{Config: kafkaclient.Config{Brokers: []string{"k2:9092", "k1:9092"}}, Transactional: true}
+	cfg := Config{Input: input.Config{Kafka: in}, Outputs: []output.Config{{Kafka: out}}}
+	assert.NoError(t, validateTransactional(cfg))
+
+	out.Brokers = []string{"other:9092"}
+	assert.Error(t, validateTransactional(cfg))
+
+	// 输入没有开启事务时输出使用自己的连接
+	in.TransactionalID = ""
+	assert.NoError(t, validateTransactional(cfg))
+}
+
+func TestPartition(t *testing.T) {
+	cases := []struct {
+		key     string
+		workers int
+	}{
+		{"shop.orders:7", 1},
+		{"shop.orders:7", 4},
+		{"shop.orders:8", 4},
+		{"orders[3]", 16},
+		{"", 3},
+	}
+	for _, c := range cases {
+		idx := partition(c.key, c.workers)
+		assert.True(t, idx >= 0 && idx < c.workers, c)
+		// 同一个键总是分到同一个 worker
+		assert.Equal(t, idx, partition(c.key, c.workers), c)
+	}
+	assert.Equal(t, 0, partition("shop.orders:7", 1))
+
+	// 键足够多时分布到所有 worker
+	used := map[int]bool{}
+	for i := 0; i < 100; i++ {
+		used[partition(fmt.Sprintf("shop.orders:%d", i), 4)] = true
+	}
+	assert.Equal(t, 4, len(used))
+}
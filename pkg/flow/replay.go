@@ -0,0 +1,95 @@
+package flow
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"go-data-flow/pkg/handler"
+	"go-data-flow/pkg/output"
+	"go-data-flow/pkg/stream"
+	"strings"
+	"sync/atomic"
+)
+
+// replayDeadLetter 将死信队列中的事件重新写入失败的输出，再次失败的事件会重新进入死信队列
+func (f *Flow) replayDeadLetter(req json.RawMessage) (ok bool, resp interface{}, err error) {
+	var request struct {
+		Flow string `json:"flow"`
+	}
+	json.Unmarshal(req, &request)
+	if request.Flow != "" && request.Flow != f.name {
+		return false, nil, nil
+	}
+	if f.deadLetter == nil {
+		return request.Flow != "", nil, fmt.Errorf("flow %s has no dead letter setting", f.name)
+	}
+	count, err := f.Replay(f.cancelable.Context())
+	if err != nil {
+		return true, nil, fmt.Errorf("flow %s replayed %d dead letters, then failed: %w", f.name, count, err)
+	}
+	return true, fmt.Sprintf("流程 %s 重放 %d 条死信", f.name, count), nil
+}
+
+// Replay 分批重放死信，每批全部被输出确认后才从死信队列中确认
+func (f *Flow) Replay(ctx context.Context) (int, error) {
+	if !atomic.CompareAndSwapInt32(&f.replaying, 0, 1) {
+		return 0, errors.New("dead letter replay is already running")
+	}
+	defer atomic.StoreInt32(&f.replaying, 0)
+
+	return f.deadLetter.Replay(ctx, func(letters []stream.DeadLetter) error {
+		acks := make([]*stream.Ack, 0, len(letters))
+		for _, letter := range letters {
+			event, err := letter.Event(ctx)
+			if err != nil {
+				// 原始数据仍然无法解析，增加重放次数后放回死信队列
+				letter.Retries++
+				letter.Error = err.Error()
+				if err = f.deadLetter.Write(ctx, letter); err != nil {
+					return err
+				}
+				continue
+			}
+			target, err := f.replayTarget(letter.Stage)
+			if err != nil {
+				letter.Retries++
+				letter.Error = err.Error()
+				if err = f.deadLetter.Write(ctx, letter); err != nil {
+					return err
+				}
+				continue
+			}
+			event.Ack = stream.NewAck()
+			err = target.OnEvent(ctx, &event)
+			event.Ack.Done(err)
+			acks = append(acks, event.Ack)
+		}
+		for _, ack := range acks {
+			if err := ack.Wait(ctx); err != nil {
+				return err
+			}
+		}
+		f.logger.Info().Int("count", len(letters)).Msg("dead letters replayed")
+		return nil
+	})
+}
+
+// replayTarget 输出阶段的死信只重放到失败的输出，如 output.elastic.document 重放到 output.elastic，
+// 其他输出已经写入，不再重复写入。输入阶段的死信还没有经过任何输出，重放到所有输出
+func (f *Flow) replayTarget(stage string) (handler.Handler, error) {
+	if !strings.HasPrefix(stage, "output.") {
+		return f.output, nil
+	}
+	var target output.Output
+	matched := ""
+	for name, item := range f.stages {
+		if (stage == name || strings.HasPrefix(stage, name+".")) && len(name) > len(matched) {
+			target, matched = item, name
+		}
+	}
+	if target == nil {
+		return nil, fmt.Errorf("output of stage %s not found", stage)
+	}
+	return target, nil
+}
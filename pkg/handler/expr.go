@@ -0,0 +1,579 @@
+package handler
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"go-data-flow/pkg/util"
+	"go-data-flow/pkg/util/jsonpath"
+)
+
+// 匹配表达式，例如：
+//
+//	age > 9 and (status in (1, 2) or name =~ "^test_") and not deleted
+//	created_at >= "2024-01-01 00:00:00" && remark is not null
+//
+// 左侧为字段路径，右侧为常量，不加引号的常量按数字、布尔、null、字符串的顺序解析，兼容旧的 `field == value` 写法。
+// 比较时两侧都能转为数字按数字比较，一侧为布尔按布尔比较，都能解析为时间按时间比较，否则按字符串比较
+type Expr interface {
+	Eval(data map[string]interface{}) bool
+}
+
+type ComparisonOperator string
+
+const (
+	Equal              ComparisonOperator = "=="
+	NotEqual           ComparisonOperator = "!="
+	GreaterThan        ComparisonOperator = ">"
+	LessThan           ComparisonOperator = "<"
+	GreaterThanOrEqual ComparisonOperator = ">="
+	LessThanOrEqual    ComparisonOperator = "<="
+	RegexMatch         ComparisonOperator = "=~"
+	RegexNotMatch      ComparisonOperator = "!~"
+	In                 ComparisonOperator = "in"
+	NotIn              ComparisonOperator = "not in"
+	Contains           ComparisonOperator = "contains"
+	Exists             ComparisonOperator = "exists"
+	IsNull             ComparisonOperator = "is null"
+	IsNotNull          ComparisonOperator = "is not null"
+)
+
+// CompileExpr 编译匹配表达式
+func CompileExpr(expr string) (Expr, error) {
+	tokens, err := tokenize(expr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid condition %s: %w", expr, err)
+	}
+	p := &exprParser{tokens: tokens}
+	ret, err := p.parseOr()
+	if err == nil && p.peek().kind != tokEOF {
+		err = fmt.Errorf("unexpected %q", p.peek().text)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("invalid condition %s: %w", expr, err)
+	}
+	return ret, nil
+}
+
+type andExpr struct{ left, right Expr }
+
+func (e andExpr) Eval(data map[string]interface{}) bool {
+	return e.left.Eval(data) && e.right.Eval(data)
+}
+
+type orExpr struct{ left, right Expr }
+
+func (e orExpr) Eval(data map[string]interface{}) bool {
+	return e.left.Eval(data) || e.right.Eval(data)
+}
+
+type notExpr struct{ expr Expr }
+
+func (e notExpr) Eval(data map[string]interface{}) bool {
+	return !e.expr.Eval(data)
+}
+
+type compareExpr struct {
+	field    string
+	operator ComparisonOperator
+	value    interface{}
+	values   []interface{}
+	regex    *regexp.Regexp
+}
+
+func (e *compareExpr) Eval(data map[string]interface{}) bool {
+	actual, exists := lookup(data, e.field)
+	switch e.operator {
+	case Exists:
+		return exists
+	case IsNull:
+		return actual == nil
+	case IsNotNull:
+		return actual != nil
+	case Equal:
+		return equals(actual, e.value)
+	case NotEqual:
+		return !equals(actual, e.value)
+	case GreaterThan, LessThan, GreaterThanOrEqual, LessThanOrEqual:
+		ret, ok := compare(actual, e.value)
+		if !ok {
+			return false
+		}
+		switch e.operator {
+		case GreaterThan:
+			return ret > 0
+		case LessThan:
+			return ret < 0
+		case GreaterThanOrEqual:
+			return ret >= 0
+		default:
+			return ret <= 0
+		}
+	case RegexMatch:
+		return actual != nil && e.regex.MatchString(toString(actual))
+	case RegexNotMatch:
+		return actual == nil || !e.regex.MatchString(toString(actual))
+	case In, NotIn:
+		found := false
+		for _, value := range e.values {
+			if equals(actual, value) {
+				found = true
+				break
+			}
+		}
+		return found == (e.operator == In)
+	case Contains:
+		return contains(actual, e.value)
+	default:
+		return false
+	}
+}
+
+// lookup 获取字段值，不包含数组下标的路径可以区分字段不存在和值为 null
+func lookup(data map[string]interface{}, path string) (interface{}, bool) {
+	if strings.Contains(path, "[") {
+		value := jsonpath.Get(data, path)
+		return value, value != nil
+	}
+	var current interface{} = data
+	for _, key := range strings.Split(path, ".") {
+		m, ok := current.(map[string]interface{})
+		if !ok {
+			return nil, false
+		}
+		if current, ok = m[key]; !ok {
+			return nil, false
+		}
+	}
+	return current, true
+}
+
+func equals(a, b interface{}) bool {
+	ret, ok := compare(a, b)
+	return ok && ret == 0
+}
+
+// compare 按类型比较两个值，无法比较时返回 false
+func compare(a, b interface{}) (int, bool) {
+	if a == nil || b == nil {
+		return 0, a == nil && b == nil
+	}
+	if x, ok := toFloat(a); ok {
+		if y, ok := toFloat(b); ok {
+			return compareOrdered(x, y), true
+		}
+	}
+	_, aBool := a.(bool)
+	_, bBool := b.(bool)
+	if aBool || bBool {
+		x, xok := toBool(a)
+		y, yok := toBool(b)
+		if !xok || !yok {
+			return 0, false
+		}
+		if x == y {
+			return 0, true
+		} else if y {
+			return -1, true
+		}
+		return 1, true
+	}
+	if x, ok := util.ToTime(a); ok {
+		if y, ok := util.ToTime(b); ok {
+			return x.Compare(y), true
+		}
+	}
+	return strings.Compare(toString(a), toString(b)), true
+}
+
+func compareOrdered(x, y float64) int {
+	switch {
+	case x < y:
+		return -1
+	case x > y:
+		return 1
+	default:
+		return 0
+	}
+}
+
+func contains(actual, value interface{}) bool {
+	if actual == nil {
+		return false
+	}
+	rv := reflect.ValueOf(actual)
+	if rv.Kind() == reflect.Slice && rv.Type().Elem().Kind() != reflect.Uint8 {
+		for i := 0; i < rv.Len(); i++ {
+			if equals(rv.Index(i).Interface(), value) {
+				return true
+			}
+		}
+		return false
+	}
+	return strings.Contains(toString(actual), toString(value))
+}
+
+func toFloat(value interface{}) (float64, bool) {
+	switch v := value.(type) {
+	case json.Number:
+		f, err := v.Float64()
+		return f, err == nil
+	case string:
+		f, err := strconv.ParseFloat(strings.TrimSpace(v), 64)
+		return f, err == nil
+	case []byte:
+		f, err := strconv.ParseFloat(strings.TrimSpace(string(v)), 64)
+		return f, err == nil
+	}
+	rv := reflect.ValueOf(value)
+	switch rv.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return float64(rv.Int()), true
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return float64(rv.Uint()), true
+	case reflect.Float32, reflect.Float64:
+		return rv.Float(), true
+	}
+	return 0, false
+}
+
+func toBool(value interface{}) (bool, bool) {
+	if v, ok := value.(bool); ok {
+		return v, true
+	}
+	if f, ok := toFloat(value); ok {
+		return f != 0, true
+	}
+	v, err := strconv.ParseBool(toString(value))
+	return v, err == nil
+}
+
+func toString(value interface{}) string {
+	switch v := value.(type) {
+	case string:
+		return v
+	case []byte:
+		return string(v)
+	default:
+		return fmt.Sprintf("%v", v)
+	}
+}
+
+type tokenKind int
+
+const (
+	tokEOF tokenKind = iota
+	tokWord
+	tokString
+	tokOp
+	tokLParen
+	tokRParen
+	tokLBracket
+	tokRBracket
+	tokComma
+)
+
+type token struct {
+	kind tokenKind
+	text string
+}
+
+var exprOperators = []string{"==", "!=", ">=", "<=", "=~", "!~", "&&", "||", ">", "<", "!"}
+
+func tokenize(expr string) ([]token, error) {
+	var tokens []token
+	runes := []rune(expr)
+	for i := 0; i < len(runes); {
+		r := runes[i]
+		switch {
+		case unicode.IsSpace(r):
+			i++
+		case r == '(':
+			tokens = append(tokens, token{tokLParen, "("})
+			i++
+		case r == ')':
+			tokens = append(tokens, token{tokRParen, ")"})
+			i++
+		case r == '[':
+			tokens = append(tokens, token{tokLBracket, "["})
+			i++
+		case r == ']':
+			tokens = append(tokens, token{tokRBracket, "]"})
+			i++
+		case r == ',':
+			tokens = append(tokens, token{tokComma, ","})
+			i++
+		case r == '"' || r == '\'':
+			var sb strings.Builder
+			j := i + 1
+			for ; j < len(runes) && runes[j] != r; j++ {
+				if runes[j] == '\\' && j+1 < len(runes) && (runes[j+1] == r || runes[j+1] == '\\') {
+					j++
+				}
+				sb.WriteRune(runes[j])
+			}
+			if j >= len(runes) {
+				return nil, fmt.Errorf("unterminated string at %d", i)
+			}
+			tokens = append(tokens, token{tokString, sb.String()})
+			i = j + 1
+		default:
+			op := ""
+			for _, candidate := range exprOperators {
+				if strings.HasPrefix(string(runes[i:]), candidate) {
+					op = candidate
+					break
+				}
+			}
+			if op != "" {
+				tokens = append(tokens, token{tokOp, op})
+				i += len([]rune(op))
+				continue
+			}
+			j := i
+			for ; j < len(runes) && !unicode.IsSpace(runes[j]) && !strings.ContainsRune(`(),'"!=<>~&|`, runes[j]); j++ {
+				// 字段路径中允许数组下标，如 items[0].id
+				if runes[j] == ']' && !strings.ContainsRune(string(runes[i:j]), '[') {
+					break
+				}
+			}
+			if j == i {
+				return nil, fmt.Errorf("unexpected %q at %d", r, i)
+			}
+			tokens = append(tokens, token{tokWord, string(runes[i:j])})
+			i = j
+		}
+	}
+	return append(tokens, token{kind: tokEOF}), nil
+}
+
+// exprParser 递归下降解析，优先级 not > and > or
+type exprParser struct {
+	tokens []token
+	pos    int
+}
+
+func (p *exprParser) peek() token {
+	return p.tokens[p.pos]
+}
+
+func (p *exprParser) next() token {
+	tok := p.tokens[p.pos]
+	if tok.kind != tokEOF {
+		p.pos++
+	}
+	return tok
+}
+
+func (p *exprParser) isKeyword(words ...string) bool {
+	tok := p.peek()
+	if tok.kind != tokWord {
+		return false
+	}
+	for _, word := range words {
+		if strings.EqualFold(tok.text, word) {
+			return true
+		}
+	}
+	return false
+}
+
+func (p *exprParser) isOp(op string) bool {
+	tok := p.peek()
+	return tok.kind == tokOp && tok.text == op
+}
+
+func (p *exprParser) parseOr() (Expr, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for p.isKeyword("or") || p.isOp("||") {
+		p.next()
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = orExpr{left, right}
+	}
+	return left, nil
+}
+
+func (p *exprParser) parseAnd() (Expr, error) {
+	left, err := p.parseNot()
+	if err != nil {
+		return nil, err
+	}
+	for p.isKeyword("and") || p.isOp("&&") {
+		p.next()
+		right, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		left = andExpr{left, right}
+	}
+	return left, nil
+}
+
+func (p *exprParser) parseNot() (Expr, error) {
+	if p.isKeyword("not") || p.isOp("!") {
+		p.next()
+		expr, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		return notExpr{expr}, nil
+	}
+	if p.peek().kind == tokLParen {
+		p.next()
+		expr, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if p.next().kind != tokRParen {
+			return nil, fmt.Errorf("missing )")
+		}
+		return expr, nil
+	}
+	return p.parseComparison()
+}
+
+func (p *exprParser) parseComparison() (Expr, error) {
+	field := p.next()
+	if field.kind != tokWord {
+		return nil, fmt.Errorf("expect field but got %q", field.text)
+	}
+	cmp := &compareExpr{field: field.text}
+	// 只有字段时按布尔值判断，如 `not deleted`
+	if end := p.peek(); end.kind == tokEOF || end.kind == tokRParen || p.isKeyword("and", "or") || p.isOp("&&") || p.isOp("||") {
+		cmp.operator, cmp.value = Equal, true
+		return cmp, nil
+	}
+	tok := p.next()
+	switch {
+	case tok.kind == tokOp:
+		cmp.operator = ComparisonOperator(tok.text)
+		switch cmp.operator {
+		case Equal, NotEqual, GreaterThan, LessThan, GreaterThanOrEqual, LessThanOrEqual:
+			value, err := p.parseLiteral()
+			if err != nil {
+				return nil, err
+			}
+			cmp.value = value
+		case RegexMatch, RegexNotMatch:
+			value := p.next()
+			if value.kind != tokString && value.kind != tokWord {
+				return nil, fmt.Errorf("expect regex but got %q", value.text)
+			}
+			regex, err := regexp.Compile(value.text)
+			if err != nil {
+				return nil, err
+			}
+			cmp.regex = regex
+		default:
+			return nil, fmt.Errorf("unexpected operator %q", tok.text)
+		}
+	case tok.kind != tokWord:
+		return nil, fmt.Errorf("expect operator after %s but got %q", field.text, tok.text)
+	case strings.EqualFold(tok.text, "in"):
+		values, err := p.parseList()
+		if err != nil {
+			return nil, err
+		}
+		cmp.operator, cmp.values = In, values
+	case strings.EqualFold(tok.text, "not"):
+		if !p.isKeyword("in") {
+			return nil, fmt.Errorf("expect in after not")
+		}
+		p.next()
+		values, err := p.parseList()
+		if err != nil {
+			return nil, err
+		}
+		cmp.operator, cmp.values = NotIn, values
+	case strings.EqualFold(tok.text, "contains"):
+		value, err := p.parseLiteral()
+		if err != nil {
+			return nil, err
+		}
+		cmp.operator, cmp.value = Contains, value
+	case strings.EqualFold(tok.text, "exists"):
+		cmp.operator = Exists
+	case strings.EqualFold(tok.text, "is"):
+		cmp.operator = IsNull
+		if p.isKeyword("not") {
+			p.next()
+			cmp.operator = IsNotNull
+		}
+		if !p.isKeyword("null") {
+			return nil, fmt.Errorf("expect null after is")
+		}
+		p.next()
+	default:
+		return nil, fmt.Errorf("unknown operator %q", tok.text)
+	}
+	return cmp, nil
+}
+
+func (p *exprParser) parseList() ([]interface{}, error) {
+	open := p.next()
+	var end tokenKind
+	switch open.kind {
+	case tokLParen:
+		end = tokRParen
+	case tokLBracket:
+		end = tokRBracket
+	default:
+		return nil, fmt.Errorf("expect list but got %q", open.text)
+	}
+	values := []interface{}{}
+	for {
+		value, err := p.parseLiteral()
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, value)
+		switch tok := p.next(); tok.kind {
+		case tokComma:
+		case end:
+			return values, nil
+		default:
+			return nil, fmt.Errorf("unexpected %q in list", tok.text)
+		}
+	}
+}
+
+func (p *exprParser) parseLiteral() (interface{}, error) {
+	tok := p.next()
+	switch tok.kind {
+	case tokString:
+		return tok.text, nil
+	case tokWord:
+		return parseValue(tok.text), nil
+	default:
+		return nil, fmt.Errorf("expect value but got %q", tok.text)
+	}
+}
+
+func parseValue(valueStr string) interface{} {
+	if i, err := strconv.ParseInt(valueStr, 10, 64); err == nil {
+		return i
+	}
+	if f, err := strconv.ParseFloat(valueStr, 64); err == nil {
+		return f
+	}
+	switch strings.ToLower(valueStr) {
+	case "true":
+		return true
+	case "false":
+		return false
+	case "null", "nil":
+		return nil
+	}
+	return valueStr
+}
@@ -0,0 +1,52 @@
+package handler
+
+import (
+	"testing"
+
+	"github.com/longbridgeapp/assert"
+)
+
+func TestCompileExpr(t *testing.T) {
+	data := map[string]interface{}{
+		"age":        int64(10),
+		"score":      "9.5",
+		"name":       "test_order",
+		"enabled":    true,
+		"status":     "2",
+		"tags":       []interface{}{"a", "b"},
+		"remark":     nil,
+		"created_at": "2024-03-01 10:00:00",
+		"user":       map[string]interface{}{"id": 7},
+	}
+	cases := map[string]bool{
+		"age > 9":                                    true,
+		"age >= 10 and age < 11":                     true,
+		"score > 10 or age == 10":                    true,
+		"not (age > 9)":                              false,
+		"!enabled":                                   false,
+		"enabled == true":                            true,
+		"status in (1, 2, 3)":                        true,
+		"status not in [1, 3]":                       true,
+		"tags contains b":                            true,
+		"name contains 'order'":                      true,
+		`name =~ "^test_"`:                           true,
+		"name !~ ^prod_":                             true,
+		"remark exists and remark is null":           true,
+		"missing exists":                             false,
+		"missing is not null":                        false,
+		`created_at > "2024-02-29 23:59:59"`:         true,
+		"created_at < 2024-03-02":                    true,
+		"user.id == 7 && (age < 5 || name != other)": true,
+		"name == test_order":                         true,
+	}
+	for expr, expect := range cases {
+		compiled, err := CompileExpr(expr)
+		assert.NoError(t, err, expr)
+		assert.Equal(t, expect, compiled.Eval(data), expr)
+	}
+
+	for _, expr := range []string{"age >", "(age > 1", "age like 1", `name =~ "("`, "age in 1"} {
+		_, err := CompileExpr(expr)
+		assert.Error(t, err, expr)
+	}
+}
@@ -16,6 +16,7 @@ const (
 	InsertEvent  EventType = "insert"
 	UpdateEvent  EventType = "update"
 	DeleteEvent  EventType = "delete"
+	DDLEvent     EventType = "ddl"
 	InvalidEvent EventType = "invalid"
 )
 
@@ -21,7 +21,9 @@ func (link *LinkHandler) OnEvent(ctx context.Context, event *stream.Event) error
 	errChan := make(chan error, len(link.list)) // 使用缓冲通道收集错误
 	for _, item := range link.list {
 		if link.Head {
-			err = item.OnEvent(ctx, deepcopy.Copy(event).(*stream.Event))
+			copied := deepcopy.Copy(event).(*stream.Event)
+			copied.Ack = event.Ack // 各输出共享同一个确认
+			err = item.OnEvent(ctx, copied)
 		} else {
 			err = item.OnEvent(ctx, item.Match(ctx, event))
 		}
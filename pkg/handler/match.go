@@ -5,78 +5,60 @@ import (
 	"fmt"
 	"reflect"
 	"regexp"
-	"strconv"
 	"strings"
 
 	"go-data-flow/pkg/stream"
-	"go-data-flow/pkg/util/jsonpath"
 )
 
-type ComparisonOperator string
-
-const (
-	Equal              ComparisonOperator = "=="
-	NotEqual           ComparisonOperator = "!="
-	GreaterThan        ComparisonOperator = ">"
-	LessThan           ComparisonOperator = "<"
-	GreaterThanOrEqual ComparisonOperator = ">="
-	LessThanOrEqual    ComparisonOperator = "<="
-)
-
-type MatchCondition struct {
-	Field    string             `yaml:"filed"`
-	Operator ComparisonOperator `yaml:"operator"`
-	Value    interface{}        `yaml:"vlaue"`
-}
-
-func ParseCondition(conditionStr string) (*MatchCondition, error) {
-	re := regexp.MustCompile(`\s*([^\s]+)\s*(==|!=|>=|<=|>|<)\s*(.+)\s*`)
-	matches := re.FindStringSubmatch(conditionStr)
-	if len(matches) < 4 {
-		return nil, fmt.Errorf("invalid condition format: %s", conditionStr)
-	}
-
-	field := matches[1]
-	operator := ComparisonOperator(matches[2])
-	valueStr := matches[3]
-
-	value, err := parseValue(valueStr)
-	if err != nil {
-		return nil, fmt.Errorf("invalid value in condition: %s", valueStr)
-	}
-
-	return &MatchCondition{
-		Field:    field,
-		Operator: operator,
-		Value:    value,
-	}, nil
-}
-
-func parseValue(valueStr string) (interface{}, error) {
-	if i, err := strconv.Atoi(valueStr); err == nil {
-		return i, nil
-	}
-	if f, err := strconv.ParseFloat(valueStr, 64); err == nil {
-		return f, nil
-	}
-	return strings.Trim(valueStr, `"'`), nil
-}
-
 type Matcher interface {
 	Match(context.Context, *stream.Event) *stream.Event
 	MatchIngrex(ctx context.Context, event *stream.Event) (bool, bool)
 	MatchData(ctx context.Context, data map[string]interface{}) bool
 }
 
+// MatchConfig 事件匹配配置，除 keys、conds 外的配置项按事件元数据匹配，例如：
+//
+//	match:
+//	  table: "shop\\.order_.*"
+//	  action: [insert, update]
+//
+// 元数据可选 source、schema、table（schema.table 全名）、action、position、timestamp，
+// 值为单个正则或正则列表，需要完整匹配其中一个，多个元数据之间为 and 关系
 type MatchConfig struct {
-	Keys  []string `yaml:"keys"`
-	Conds []string `yaml:"conds"`
+	Keys  []string            `yaml:"keys"`  // 匹配 Topic 的正则
+	Conds []string            `yaml:"conds"` // 匹配表达式，语法见 CompileExpr，多个表达式之间为 and 关系
+	Meta  map[string]Patterns `yaml:",inline"`
 }
 
 func (f MatchConfig) IsZero() bool {
-	return len(f.Keys) == 0 && len(f.Conds) == 0
+	return len(f.Keys) == 0 && len(f.Conds) == 0 && len(f.Meta) == 0
+}
+
+// Patterns 可以配置为单个正则或正则列表
+type Patterns []string
+
+func (p *Patterns) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var list []string
+	if err := unmarshal(&list); err == nil {
+		*p = list
+		return nil
+	}
+	var single string
+	if err := unmarshal(&single); err != nil {
+		return err
+	}
+	*p = Patterns{single}
+	return nil
+}
+
+var metaNames = map[string]bool{
+	"source": true, "schema": true, "table": true, "action": true, "position": true, "timestamp": true,
+	"topic": true, "partition": true, "offset": true, "key": true,
 }
 
+// metaHeaderPrefix kafka 消息头，例如 header.trace_id
+const metaHeaderPrefix = "header."
+
 func GetMatchConfig(val reflect.Value) MatchConfig {
 	if val.Kind() == reflect.Ptr {
 		val = val.Elem()
@@ -99,41 +81,57 @@ func GetMatchConfig(val reflect.Value) MatchConfig {
 }
 
 type DefaultMatcher struct {
-	inRegexs []*regexp.Regexp
-	Conds    []*MatchCondition
+	inRegexs   []*regexp.Regexp
+	metaRegexs map[string][]*regexp.Regexp
+	Conds      []Expr // 多个条件之间为 and 关系
 }
 
 func NewDefaultMatcher(config MatchConfig) (*DefaultMatcher, error) {
 	in := []*regexp.Regexp{}
 	for _, reg := range config.Keys {
-		in = append(in, regexp.MustCompile(reg))
+		regex, err := regexp.Compile(reg)
+		if err != nil {
+			return nil, fmt.Errorf("invalid match keys regex %s: %w", reg, err)
+		}
+		in = append(in, regex)
 	}
-	conds := []*MatchCondition{}
+	metaRegexs := map[string][]*regexp.Regexp{}
+	for name, patterns := range config.Meta {
+		if !metaNames[name] && !strings.HasPrefix(name, metaHeaderPrefix) {
+			return nil, fmt.Errorf("unknown match key %s", name)
+		}
+		for _, pattern := range patterns {
+			regex, err := regexp.Compile("^(?:" + pattern + ")$")
+			if err != nil {
+				return nil, fmt.Errorf("invalid match %s regex %s: %w", name, pattern, err)
+			}
+			metaRegexs[name] = append(metaRegexs[name], regex)
+		}
+	}
+	conds := []Expr{}
 	for _, condExpr := range config.Conds {
-		cond, err := ParseCondition(condExpr)
+		cond, err := CompileExpr(condExpr)
 		if err != nil {
 			return nil, err
 		}
 		conds = append(conds, cond)
 	}
 	return &DefaultMatcher{
-		inRegexs: in,
-		Conds:    conds,
+		inRegexs:   in,
+		metaRegexs: metaRegexs,
+		Conds:      conds,
 	}, nil
 }
 
 func (f *DefaultMatcher) Match(ctx context.Context, event *stream.Event) (out *stream.Event) {
 	_, matched := f.MatchIngrex(ctx, event)
-	out = &stream.Event{}
-	out.Context = event.Context
-	out.Topic = event.Topic
-	out.Datas = []map[string]interface{}{}
+	out = event.WithDatas([]map[string]interface{}{})
+	// keys 和元数据不匹配时不再按条件过滤数据
+	if !matched {
+		return out
+	}
 	if len(f.Conds) == 0 {
-		if matched {
-			return event
-		} else {
-			return out
-		}
+		return event
 	}
 
 	for _, data := range event.Datas {
@@ -156,35 +154,24 @@ func (f *DefaultMatcher) MatchIngrex(ctx context.Context, event *stream.Event) (
 		}
 		matched = find
 	}
-	return len(f.inRegexs) > 0, matched
+	for name, regexs := range f.metaRegexs {
+		value, _ := event.Meta.Get(name)
+		find := false
+		for _, regex := range regexs {
+			if regex.MatchString(value) {
+				find = true
+				break
+			}
+		}
+		matched = matched && find
+	}
+	return len(f.inRegexs) > 0 || len(f.metaRegexs) > 0, matched
 }
 func (f *DefaultMatcher) MatchData(ctx context.Context, data map[string]interface{}) bool {
 	for _, condition := range f.Conds {
-		if !evaluateCondition(jsonpath.Get(data, condition.Field), condition.Operator, condition.Value) {
+		if !condition.Eval(data) {
 			return false
 		}
 	}
 	return true
 }
-
-func evaluateCondition(actual interface{}, operator ComparisonOperator, target interface{}) bool {
-	actualValue := fmt.Sprintf("%v", actual)
-	targetValue := fmt.Sprintf("%v", target)
-
-	switch operator {
-	case Equal:
-		return actualValue == targetValue
-	case NotEqual:
-		return actualValue != targetValue
-	case GreaterThan:
-		return actualValue > targetValue
-	case LessThan:
-		return actualValue < targetValue
-	case GreaterThanOrEqual:
-		return actualValue >= targetValue
-	case LessThanOrEqual:
-		return actualValue <= targetValue
-	default:
-		return false
-	}
-}
@@ -0,0 +1,65 @@
+package handler
+
+import (
+	"context"
+	"testing"
+
+	"go-data-flow/pkg/stream"
+
+	"github.com/longbridgeapp/assert"
+	"gopkg.in/yaml.v2"
+)
+
+func TestMatchMeta(t *testing.T) {
+	var cfg struct {
+		Match MatchConfig `yaml:"match"`
+	}
+	raw := `
+match:
+  table: "shop\\.order_.*"
+  action: [insert, update]
+`
+	assert.NoError(t, yaml.Unmarshal([]byte(raw), &cfg))
+	matcher, err := NewDefaultMatcher(cfg.Match)
+	assert.NoError(t, err)
+
+	cases := []struct {
+		meta    stream.Meta
+		matched bool
+	}{
+		{stream.Meta{Schema: "shop", Table: "order_1", Action: "insert"}, true},
+		{stream.Meta{Schema: "shop", Table: "order_1", Action: "delete"}, false},
+		{stream.Meta{Schema: "shop", Table: "user", Action: "update"}, false},
+		{stream.Meta{Schema: "myshop", Table: "order_1", Action: "update"}, false},
+	}
+	for _, c := range cases {
+		has, matched := matcher.MatchIngrex(context.Background(), &stream.Event{Meta: c.meta})
+		assert.True(t, has)
+		assert.Equal(t, c.matched, matched, c.meta)
+	}
+
+	_, err = NewDefaultMatcher(MatchConfig{Meta: map[string]Patterns{"tabel": {"x"}}})
+	assert.Error(t, err)
+	_, err = NewDefaultMatcher(MatchConfig{Keys: []string{"orders("}})
+	assert.Error(t, err)
+}
+
+func TestMatchWithConds(t *testing.T) {
+	matcher, err := NewDefaultMatcher(MatchConfig{
+		Keys:  []string{"orders"},
+		Conds: []string{"status == paid"},
+		Meta:  map[string]Patterns{"table": {"shop\\.orders"}},
+	})
+	assert.NoError(t, err)
+	datas := []map[string]interface{}{{"status": "paid"}, {"status": "created"}}
+
+	event := &stream.Event{Topic: "orders", Meta: stream.Meta{Schema: "shop", Table: "orders"}, Datas: datas}
+	out := matcher.Match(context.Background(), event)
+	assert.Equal(t, []map[string]interface{}{{"status": "paid"}}, out.Datas)
+
+	// 其他表的事件即使满足条件也被过滤
+	event = &stream.Event{Topic: "orders", Meta: stream.Meta{Schema: "shop", Table: "users"}, Datas: datas}
+	assert.Equal(t, 0, len(matcher.Match(context.Background(), event).Datas))
+	event = &stream.Event{Topic: "users", Meta: stream.Meta{Schema: "shop", Table: "orders"}, Datas: datas}
+	assert.Equal(t, 0, len(matcher.Match(context.Background(), event).Datas))
+}
@@ -9,7 +9,9 @@ import (
 	"go-data-flow/pkg/logs"
 	"go-data-flow/pkg/stream"
 	"go-data-flow/pkg/util/containers/slices"
+	"reflect"
 	"regexp"
+	"strings"
 	"sync"
 	"sync/atomic"
 	"time"
@@ -28,10 +30,18 @@ type Canal struct {
 	cfg           *Config
 	stream        *stream.Scream
 	posSaver      PosSaver
+	checkpoint    *stream.Checkpointer
 	tables        map[string]*schema.Table
+	ignoreColumns []*regexp.Regexp
 	isIncremental int32
+	snapshotting  int32
+	progress      *SnapshotProgress
+	progressMu    sync.Mutex
 	incrementCond *sync.Cond
 	restart       chan int
+	resetTo       *syncPoint
+	resetMu       sync.Mutex
+	runCtx        context.Context // Run 的 ctx，带有防护令牌
 	logger        zerolog.Logger
 }
 
@@ -43,25 +53,49 @@ const (
 )
 
 type Config struct {
-	Addr              string   `yaml:"addr"`
-	User              string   `yaml:"user"`
-	Password          string   `yaml:"password"`
-	ServerID          uint32   `yaml:"server_id"`
-	IncludeTableRegex []string `yaml:"include_table_regex"`
-	ExcludeTableRegex []string `yaml:"exclude_table_regex"`
-	MonitorInter      int      `yaml:"monitor_inter"`
-	DelayPos          int      `yaml:"delay_pos"`
-	FilterActions     []string `yaml:"filter_actions"`
-	FullSyncPageSize  int      `yaml:"full_sync_page_size"`
+	Addr               string         `yaml:"addr"`
+	User               string         `yaml:"user"`
+	Password           string         `yaml:"password"`
+	ServerID           uint32         `yaml:"server_id"`
+	IncludeTableRegex  []string       `yaml:"include_table_regex"`
+	ExcludeTableRegex  []string       `yaml:"exclude_table_regex"`
+	MonitorInter       int            `yaml:"monitor_inter"`
+	DelayPos           int            `yaml:"delay_pos"`
+	DelayGTID          int            `yaml:"delay_gtid"` // GTID 模式下落后的事务数超过时告警
+	FilterActions      []string       `yaml:"filter_actions"`
+	FullSyncPageSize   int            `yaml:"full_sync_page_size"`
+	FullSyncRowsPerSec int            `yaml:"full_sync_rows_per_sec"` // 全量同步限速，0 表示不限制
+	FullSyncLock       bool           `yaml:"full_sync_lock"`         // 开启快照时加全局读锁，位置与快照完全一致，需要 RELOAD 权限
+	GTIDMode           bool           `yaml:"gtid_mode"`              // 使用 GTID 保存同步位置，主从切换后可以继续同步
+	Flavor             string         `yaml:"flavor"`                 // mysql 或 mariadb
+	EmitDDL            bool           `yaml:"emit_ddl"`               // 将表结构变更作为 ddl 事件发送到流程中
+	PosStore           PosStoreConfig `yaml:"pos_store"`
+	// 匹配 schema.table.column，update 只修改了这些列时不同步
+	UpdateIgnoreColumnRegex []string `yaml:"update_ignore_column_regex"`
 }
 
 func NewCanal(cfg *Config, posSaver PosSaver, stream *stream.Scream) (*Canal, error) {
 	if cfg.DelayPos == 0 {
 		cfg.DelayPos = 1000000
 	}
+	if cfg.DelayGTID == 0 {
+		cfg.DelayGTID = 10000
+	}
 	if cfg.FullSyncPageSize == 0 {
 		cfg.FullSyncPageSize = 1000
 	}
+	if cfg.Flavor == "" {
+		cfg.Flavor = mysql.MySQLFlavor
+	}
+
+	ignoreColumns := []*regexp.Regexp{}
+	for _, expr := range cfg.UpdateIgnoreColumnRegex {
+		regex, err := regexp.Compile(expr)
+		if err != nil {
+			return nil, err
+		}
+		ignoreColumns = append(ignoreColumns, regex)
+	}
 
 	// 初始化 MySQL 连接
 	dsn := fmt.Sprintf("%s:%s@tcp(%s)/", cfg.User, cfg.Password, cfg.Addr)
@@ -79,6 +113,7 @@ func NewCanal(cfg *Config, posSaver PosSaver, stream *stream.Scream) (*Canal, er
 		posSaver:      posSaver,
 		stream:        stream,
 		tables:        make(map[string]*schema.Table),
+		ignoreColumns: ignoreColumns,
 		isIncremental: 1,                           // 初始状态允许增量同步
 		incrementCond: sync.NewCond(&sync.Mutex{}), // 条件变量用于控制全量同步
 		restart:       make(chan int),
@@ -94,22 +129,41 @@ func (c *Canal) getCanalInstance() (*canal.Canal, error) {
 	canalCfg.User = c.cfg.User
 	canalCfg.Password = c.cfg.Password
 	canalCfg.ServerID = c.cfg.ServerID
+	canalCfg.Flavor = c.cfg.Flavor
 	canalCfg.IncludeTableRegex = c.cfg.IncludeTableRegex
 	canalCfg.Dump.ExecutionPath = ""
 	return canal.NewCanal(canalCfg)
 }
 
 func (c *Canal) Run(ctx context.Context) (err error) {
+	c.resetMu.Lock()
+	c.runCtx = ctx
+	c.resetMu.Unlock()
 	tryCnt := 0
 	backoff := time.Second
+	defer func() {
+		if c.checkpoint != nil {
+			c.checkpoint.Stop()
+		}
+	}()
 	for ctx.Err() == nil {
+		// 每次重连都从最后一次保存的位置开始，之前未确认的位置全部丢弃
+		if c.checkpoint != nil {
+			c.checkpoint.Stop()
+		}
+
 		//延迟创建
 		cli, err := c.getCanalInstance()
 		if err != nil {
 			return fmt.Errorf("create canal failed %w", err)
 		}
 		c.cli = cli
-		lastpos, err := c.posSaver.Get()
+		c.checkpoint = stream.NewCheckpointer(ctx, func(err error) {
+			// 输出写入失败，断开连接后从最后一次保存的位置重新同步
+			c.stream.Err <- fmt.Errorf("canal %s output failed, resync from last saved position: %w", c.cfg.Addr, err)
+			cli.Close()
+		})
+		lastpos, err := c.lastSyncPoint()
 		if err != nil {
 			return fmt.Errorf("get last canal pos failed: %w", err)
 		}
@@ -118,16 +172,19 @@ func (c *Canal) Run(ctx context.Context) (err error) {
 		if err = c.getSyncTable(); err != nil {
 			return err
 		}
-		if lastpos.Name == "" {
-			pos, err := c.getCurrentBinlogPosition()
+		if lastpos.empty() {
+			// 全量同步，从快照对应的位置开始增量同步
+			progress, err := c.syncFullData(ctx, c.tables)
 			if err != nil {
-				return fmt.Errorf("get current binlog position failed: %w", err)
+				return err
 			}
-			lastpos = pos
-			// 全量同步
-			if err = c.syncFullData(ctx, c.tables); err != nil {
+			if lastpos, err = c.snapshotSyncPoint(progress); err != nil {
 				return err
 			}
+			// 全量数据全部确认后保存起始位置，之后重启直接增量同步
+			c.saveSyncPoint(lastpos)
+		} else if err = c.resumeResync(ctx); err != nil {
+			return err
 		}
 		go func() {
 			if c.cfg.MonitorInter > 0 {
@@ -137,7 +194,7 @@ func (c *Canal) Run(ctx context.Context) (err error) {
 				}
 			}
 		}()
-		c.logger.Info().Any("sync from postion", lastpos).Msgf("")
+		c.logger.Info().Str("sync from postion", lastpos.String()).Msgf("")
 
 		if tryCnt > 10 {
 			c.stream.Err <- fmt.Errorf("maximum retry attempts reached ")
@@ -146,8 +203,8 @@ func (c *Canal) Run(ctx context.Context) (err error) {
 			backoff = 1
 		}
 
-		if err = c.cli.RunFrom(lastpos); err != nil {
-			c.logger.Info().Any("sync from postion", lastpos).Err(err).Msgf("")
+		if err = c.runFrom(lastpos); err != nil {
+			c.logger.Info().Str("sync from postion", lastpos.String()).Err(err).Msgf("")
 			if errors.Is(err, context.Canceled) {
 
 			} else {
@@ -162,7 +219,92 @@ func (c *Canal) Run(ctx context.Context) (err error) {
 	return
 }
 
+// syncPoint 增量同步的起始位置，GTID 模式下使用 GTIDSet，否则使用 binlog 文件位置
+type syncPoint struct {
+	pos  mysql.Position
+	gtid mysql.GTIDSet
+}
+
+func (p syncPoint) empty() bool {
+	return p.gtid == nil && p.pos.Name == ""
+}
+
+func (p syncPoint) String() string {
+	if p.gtid != nil {
+		return p.gtid.String()
+	}
+	return p.pos.String()
+}
+
+// lastSyncPoint 优先使用命令指定的位置，否则使用最后一次保存的位置
+func (c *Canal) lastSyncPoint() (syncPoint, error) {
+	c.resetMu.Lock()
+	if c.resetTo != nil {
+		point := *c.resetTo
+		c.resetTo = nil
+		c.resetMu.Unlock()
+		return point, nil
+	}
+	c.resetMu.Unlock()
+
+	if c.cfg.GTIDMode {
+		raw, err := c.posSaver.GetGTID()
+		if err != nil || raw == "" {
+			return syncPoint{}, err
+		}
+		set, err := mysql.ParseGTIDSet(c.cfg.Flavor, raw)
+		if err != nil {
+			return syncPoint{}, fmt.Errorf("parse saved gtid set %s failed: %w", raw, err)
+		}
+		return syncPoint{gtid: set}, nil
+	}
+	pos, err := c.posSaver.Get()
+	return syncPoint{pos: pos}, err
+}
+
+func (c *Canal) snapshotSyncPoint(progress *SnapshotProgress) (syncPoint, error) {
+	if !c.cfg.GTIDMode {
+		return syncPoint{pos: progress.Position}, nil
+	}
+	set, err := mysql.ParseGTIDSet(c.cfg.Flavor, progress.GTIDSet)
+	if err != nil {
+		return syncPoint{}, fmt.Errorf("parse snapshot gtid set %s failed: %w", progress.GTIDSet, err)
+	}
+	return syncPoint{gtid: set}, nil
+}
+
+func (c *Canal) saveSyncPoint(point syncPoint) {
+	if point.gtid != nil {
+		c.saveGTID(point.gtid)
+		return
+	}
+	c.savePos(point.pos)
+}
+
+func (c *Canal) runFrom(point syncPoint) error {
+	if point.gtid != nil {
+		return c.cli.StartFromGTID(point.gtid)
+	}
+	return c.cli.RunFrom(point.pos)
+}
+
+// resetSyncPoint 断开连接后从指定的位置重新开始同步
+func (c *Canal) resetSyncPoint(point syncPoint) {
+	c.resetMu.Lock()
+	c.resetTo = &point
+	c.resetMu.Unlock()
+	select {
+	case c.restart <- 1:
+	default:
+	}
+	//reset connection
+	c.cli.Close()
+}
+
 func (c *Canal) SyncFromPos(pos mysql.Position) error {
+	if c.cfg.GTIDMode {
+		return errors.New("canal is running in gtid mode, sync from gtid set instead")
+	}
 	ok, err := c.checkPositionAvaliable(pos)
 	if err != nil {
 		return err
@@ -170,12 +312,42 @@ func (c *Canal) SyncFromPos(pos mysql.Position) error {
 	if !ok {
 		return errors.New("the specified position does not exist")
 	}
-	c.restart <- 1
-	c.posSaver.Save(pos)
-	//reset connection
-	c.cli.Close()
+	ctx := c.runContext()
+	if err = stream.CheckFence(ctx); err != nil {
+		return err
+	}
+	if err = c.posSaver.Save(withForceSave(ctx), pos); err != nil {
+		return err
+	}
+	c.resetSyncPoint(syncPoint{pos: pos})
 	return nil
 }
+
+func (c *Canal) SyncFromGTID(set mysql.GTIDSet) error {
+	if !c.cfg.GTIDMode {
+		return errors.New("canal is not running in gtid mode")
+	}
+	ctx := c.runContext()
+	if err := stream.CheckFence(ctx); err != nil {
+		return err
+	}
+	if err := c.posSaver.SaveGTID(withForceSave(ctx), set.String()); err != nil {
+		return err
+	}
+	c.resetSyncPoint(syncPoint{gtid: set.Clone()})
+	return nil
+}
+
+// runContext 命令直接保存位置时也要带上防护令牌，Run 之前没有令牌
+func (c *Canal) runContext() context.Context {
+	c.resetMu.Lock()
+	defer c.resetMu.Unlock()
+	if c.runCtx == nil {
+		return context.Background()
+	}
+	return c.runCtx
+}
+
 func (c *Canal) Close() {
 	c.cli.Close()
 }
@@ -223,30 +395,67 @@ func (c *Canal) getSyncTable() error {
 }
 
 func (c *Canal) getCurrentBinlogPosition() (mysql.Position, error) {
+	pos, _, err := c.queryBinlogPosition(context.Background(), c.db)
+	return pos, err
+}
+
+type queryer interface {
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+}
 
-	res, err := c.db.Query("SHOW MASTER STATUS")
+// queryBinlogPosition 返回当前 binlog 位置和已执行的 GTIDSet
+func (c *Canal) queryBinlogPosition(ctx context.Context, db queryer) (mysql.Position, string, error) {
+
+	res, err := db.QueryContext(ctx, "SHOW MASTER STATUS")
 	if err != nil {
-		return mysql.Position{}, fmt.Errorf("failed to execute SHOW MASTER STATUS: %w", err)
+		return mysql.Position{}, "", fmt.Errorf("failed to execute SHOW MASTER STATUS: %w", err)
 	}
 	defer res.Close()
 
 	if !res.Next() {
-		return mysql.Position{}, fmt.Errorf("empty result from SHOW MASTER STATUS")
+		return mysql.Position{}, "", fmt.Errorf("empty result from SHOW MASTER STATUS")
 	}
 
 	var binlogFile string
 	var binlogPos uint32
-	var unused1, unused2, unused3 sql.RawBytes
-	if err := res.Scan(&binlogFile, &binlogPos, &unused1, &unused2, &unused3); err != nil {
-		return mysql.Position{}, fmt.Errorf("failed to scan SHOW MASTER STATUS result: %w", err)
+	var unused1, unused2 sql.RawBytes
+	var gtidSet sql.NullString
+	// mariadb 没有 Executed_Gtid_Set 列，GTID 从 @@gtid_binlog_pos 获取
+	dest := []interface{}{&binlogFile, &binlogPos, &unused1, &unused2}
+	if c.cfg.Flavor != mysql.MariaDBFlavor {
+		dest = append(dest, &gtidSet)
+	}
+	if err := res.Scan(dest...); err != nil {
+		return mysql.Position{}, "", fmt.Errorf("failed to scan SHOW MASTER STATUS result: %w", err)
+	}
+	res.Close()
+	if c.cfg.Flavor == mysql.MariaDBFlavor {
+		if gtidSet, err = queryMariadbGTID(ctx, db); err != nil {
+			return mysql.Position{}, "", err
+		}
 	}
 
-	c.logger.Info().Str(logs.Canal, c.cfg.Addr).Str("binlog_file", binlogFile).Uint32("binlog_pos", binlogPos).Msg("current binlog position")
+	c.logger.Info().Str(logs.Canal, c.cfg.Addr).Str("binlog_file", binlogFile).Uint32("binlog_pos", binlogPos).Str("gtid_set", gtidSet.String).Msg("current binlog position")
 
 	return mysql.Position{
 		Name: binlogFile,
 		Pos:  binlogPos,
-	}, nil
+	}, gtidSet.String, nil
+}
+
+func queryMariadbGTID(ctx context.Context, db queryer) (sql.NullString, error) {
+	var gtidSet sql.NullString
+	res, err := db.QueryContext(ctx, "SELECT @@gtid_binlog_pos")
+	if err != nil {
+		return gtidSet, fmt.Errorf("failed to query gtid_binlog_pos: %w", err)
+	}
+	defer res.Close()
+	if res.Next() {
+		if err = res.Scan(&gtidSet); err != nil {
+			return gtidSet, fmt.Errorf("failed to scan gtid_binlog_pos: %w", err)
+		}
+	}
+	return gtidSet, res.Err()
 }
 
 func (c *Canal) checkPositionAvaliable(pos mysql.Position) (bool, error) {
@@ -273,59 +482,6 @@ func (c *Canal) checkPositionAvaliable(pos mysql.Position) (bool, error) {
 	return false, nil
 }
 
-func (c *Canal) syncFullData(ctx context.Context, tables map[string]*schema.Table) error {
-	// 全量同步时，堵塞增量同步，防止 binlog 事件丢失
-	atomic.StoreInt32(&c.isIncremental, 0)
-	defer func() {
-		atomic.StoreInt32(&c.isIncremental, 1)
-		c.incrementCond.Broadcast()
-	}()
-
-	for _, table := range tables {
-		var offset int
-		for {
-			// 在每次分页前检查上下文是否已取消，确保可以及时响应取消请求
-			if ctx.Err() != nil {
-				return ctx.Err()
-			}
-
-			query := fmt.Sprintf("SELECT * FROM %s.%s LIMIT %d OFFSET %d", table.Schema, table.Name, c.cfg.FullSyncPageSize, offset)
-			rows, err := c.db.QueryContext(ctx, query)
-			if err != nil {
-				return fmt.Errorf("failed to sync full data from %s: %w", table.Name, err)
-			}
-			defer rows.Close()
-
-			var values [][]interface{}
-			for rows.Next() {
-				rowData, scanErr := ScanRow(rows)
-				if scanErr != nil {
-					return fmt.Errorf("failed to scan row for table %s: %w", table.Name, scanErr)
-				}
-				values = append(values, rowData)
-			}
-			if len(values) == 0 {
-				break
-			}
-
-			if err = c.storeFullData(ctx, table, values); err != nil {
-				return fmt.Errorf("failed to store full data for table %s: %w", table.Name, err)
-			}
-
-			c.logger.Info().
-				Str("database", table.Schema).
-				Str("table", table.Name).
-				Str("sync query", query).
-				Msg("Successfully synced a page of full data")
-
-			offset += c.cfg.FullSyncPageSize
-			time.Sleep(time.Second * 2) // 控制频率，避免对数据库造成过大压力
-		}
-	}
-
-	return nil
-}
-
 func ScanRow(rows *sql.Rows) ([]interface{}, error) {
 	columns, err := rows.Columns()
 	if err != nil {
@@ -346,7 +502,8 @@ func ScanRow(rows *sql.Rows) ([]interface{}, error) {
 	return values, nil
 }
 
-func (c *Canal) storeFullData(ctx context.Context, table *schema.Table, values [][]interface{}) error {
+// storeFullData 全量数据的位置为快照开始时的 binlog 位置，快照之后的变更位置更大
+func (c *Canal) storeFullData(ctx context.Context, table *schema.Table, pos mysql.Position, values [][]interface{}) error {
 	rows := make([][]interface{}, len(values))
 	for ridx, row := range values {
 		values := make([]interface{}, len(table.Columns))
@@ -359,7 +516,11 @@ func (c *Canal) storeFullData(ctx context.Context, table *schema.Table, values [
 		}
 		rows[ridx] = values
 	}
-	return c.process(ctx, table.Schema, table.Name, string(handler.InsertEvent), rows)
+	meta := stream.Meta{Source: c.cfg.Addr, Schema: table.Schema, Table: table.Name, Action: string(handler.InsertEvent)}
+	if pos.Name != "" {
+		meta.Position = fmt.Sprintf("%s:%d", pos.Name, pos.Pos)
+	}
+	return c.process(ctx, meta, rows)
 }
 
 func (c *Canal) GetTable(schema, table string) (*schema.Table, error) {
@@ -374,7 +535,13 @@ func (c *Canal) OnTableChanged(schema, table string) {
 	delete(c.tables, fmt.Sprintf("%s.%s", schema, table))
 }
 
-func (c *Canal) OnEvent(ctx context.Context, schema, table string, action string, rows [][]interface{}) error {
+func (c *Canal) OnEvent(ctx context.Context, meta stream.Meta, rows [][]interface{}) error {
+	c.waitIncremental()
+	return c.process(ctx, meta, rows)
+}
+
+// waitIncremental 全量同步期间堵塞增量事件
+func (c *Canal) waitIncremental() {
 	if atomic.LoadInt32(&c.isIncremental) == 0 {
 		c.incrementCond.L.Lock()
 		for atomic.LoadInt32(&c.isIncremental) == 0 {
@@ -382,47 +549,244 @@ func (c *Canal) OnEvent(ctx context.Context, schema, table string, action string
 		}
 		c.incrementCond.L.Unlock()
 	}
-	return c.process(ctx, schema, table, action, rows)
 }
-func (c *Canal) process(ctx context.Context, schema, table string, action string, rows [][]interface{}) error {
-	meta, err := c.GetTable(schema, table)
+
+// rowChange 一行数据的变更，insert/delete 只有 after，update 同时带有变更前的 before
+type rowChange struct {
+	before  []interface{}
+	after   []interface{}
+	changed []string
+}
+
+func toRowChanges(action string, rows [][]interface{}) []rowChange {
+	if action != canal.UpdateAction {
+		changes := make([]rowChange, len(rows))
+		for idx, row := range rows {
+			changes[idx] = rowChange{after: row}
+		}
+		return changes
+	}
+	// update 事件的行按 before、after 成对出现
+	changes := make([]rowChange, 0, len(rows)/2)
+	for idx := 0; idx+1 < len(rows); idx += 2 {
+		changes = append(changes, rowChange{before: rows[idx], after: rows[idx+1]})
+	}
+	return changes
+}
+
+func (c *Canal) process(ctx context.Context, eventMeta stream.Meta, rows [][]interface{}) error {
+	action := eventMeta.Action
+	meta, err := c.GetTable(eventMeta.Schema, eventMeta.Table)
 	if err != nil {
 		return err
 	}
-	for _, rows := range slices.Chunk(rows, 10) {
-		drows := make([]map[string]interface{}, len(rows))
-		for idx, row := range rows {
-			ret := make(map[string]interface{})
-			for idx, col := range meta.Columns {
-				ret[col.Name] = row[idx]
+	// 按主键分组，同一行的变更使用同一个分区键，保证按顺序写入
+	keys := []string{}
+	groups := map[string][]rowChange{}
+	flush := func() error {
+		for _, key := range keys {
+			if err := c.emitChanges(ctx, key, eventMeta, meta, groups[key]); err != nil {
+				return err
 			}
-			drows[idx] = ret
 		}
+		keys, groups = keys[:0], map[string][]rowChange{}
+		return nil
+	}
+	for _, change := range toRowChanges(action, rows) {
+		if change.before != nil {
+			change.changed = changedColumns(meta, change.before, change.after)
+			if !c.isUpdateConcerned(meta, change.changed) {
+				continue
+			}
+		}
+		key := rowKey(meta, change.after)
+		if change.before != nil {
+			if before := rowKey(meta, change.before); before != key {
+				// 主键变更的行与旧主键和新主键的事件都要保持顺序，分区键只能取一个，
+				// 先等之前的事件全部写入再单独发送，确认写入后才继续发送之后的事件
+				if err := flush(); err != nil {
+					return err
+				}
+				if err := c.checkpoint.Drain(ctx); err != nil {
+					return err
+				}
+				if err := c.emitChanges(ctx, before, eventMeta, meta, []rowChange{change}); err != nil {
+					return err
+				}
+				if err := c.checkpoint.Drain(ctx); err != nil {
+					return err
+				}
+				continue
+			}
+		}
+		if _, ok := groups[key]; !ok {
+			keys = append(keys, key)
+		}
+		groups[key] = append(groups[key], change)
+	}
+	return flush()
+}
+
+// emitChanges 同一个分区键的变更每 10 行合并为一个事件发送
+func (c *Canal) emitChanges(ctx context.Context, key string, eventMeta stream.Meta, meta *schema.Table, changes []rowChange) error {
+	action := eventMeta.Action
+	for _, changes := range slices.Chunk(changes, 10) {
 		data := map[string]interface{}{
 			"action": action,
-			"rows":   drows,
-			"table":  fmt.Sprintf("%s.%s", schema, table),
-		}
-		event := stream.Event{Context: ctx, Topic: c.cfg.Addr, Datas: []map[string]interface{}{data}}
-		c.stream.In <- event
-		result := <-c.stream.Out
-		if result.Error != nil {
-			c.logger.Err(err).Any(logs.Input, "Canal").Any("event", event).Msg("process error")
+			"table":  fmt.Sprintf("%s.%s", eventMeta.Schema, eventMeta.Table),
+		}
+		afters := make([]map[string]interface{}, len(changes))
+		for idx, change := range changes {
+			afters[idx] = rowMap(meta, change.after)
+		}
+		data["rows"] = afters
+		if action == canal.UpdateAction {
+			befores := make([]map[string]interface{}, len(changes))
+			changed := make([][]string, len(changes))
+			for idx, change := range changes {
+				befores[idx] = rowMap(meta, change.before)
+				changed[idx] = change.changed
+			}
+			data["before"] = befores
+			data["changed"] = changed
+		}
+		if err := c.emit(ctx, key, eventMeta, data); err != nil {
 			return err
 		}
 	}
 	return nil
 }
 
-func (c *Canal) savePos(pos mysql.Position) error {
-	c.logger.Info().
-		Str(logs.Canal, c.cfg.Addr).
-		Any("pos", fmt.Sprintf("%s.%d", pos.Name, pos.Pos)).Msg("save pos")
-	err := c.posSaver.Save(pos)
-	if err != nil {
-		c.logger.Error().Err(err).Msg("save pos failed")
+// emit 发送事件到流程中，并记录到确认队列
+func (c *Canal) emit(ctx context.Context, key string, meta stream.Meta, data map[string]interface{}) error {
+	event := stream.Event{Context: ctx, Topic: c.cfg.Addr, Datas: []map[string]interface{}{data}, Key: key, Meta: meta, Ack: stream.NewAck()}
+	c.checkpoint.Track(event.Ack)
+	c.stream.In <- event
+	result := <-c.stream.Out
+	if result.Error != nil {
+		c.logger.Err(result.Error).Any(logs.Input, "Canal").Any("event", event).Msg("process error")
+		return result.Error
+	}
+	return nil
+}
+
+// OnSchemaChanged 发送 DDL 事件，携带语句和变更后的列信息，表被删除时列为空
+func (c *Canal) OnSchemaChanged(ctx context.Context, eventMeta stream.Meta, statement string) error {
+	schemaName, table := eventMeta.Schema, eventMeta.Table
+	c.waitIncremental()
+	fullName := fmt.Sprintf("%s.%s", schemaName, table)
+	columns := []map[string]interface{}{}
+	meta, err := c.cli.GetTable(schemaName, table)
+	if errors.Is(err, canal.ErrExcludedTable) {
+		return nil
+	} else if err != nil {
+		c.logger.Warn().Err(err).Str("table", fullName).Msg("get table info after ddl failed")
+	} else {
+		for idx, col := range meta.Columns {
+			columns = append(columns, map[string]interface{}{
+				"name":     col.Name,
+				"raw_type": col.RawType,
+				"unsigned": col.IsUnsigned,
+				"pk":       meta.IsPrimaryKey(idx),
+			})
+		}
+	}
+	data := map[string]interface{}{
+		"action":    string(handler.DDLEvent),
+		"table":     fullName,
+		"schema":    schemaName,
+		"statement": statement,
+		"columns":   columns,
+	}
+	return c.emit(ctx, fullName, eventMeta, data)
+}
+
+func rowMap(table *schema.Table, row []interface{}) map[string]interface{} {
+	ret := make(map[string]interface{}, len(table.Columns))
+	for idx, col := range table.Columns {
+		if idx < len(row) {
+			ret[col.Name] = row[idx]
+		}
+	}
+	return ret
+}
+
+// changedColumns 返回 update 前后值不同的列
+func changedColumns(table *schema.Table, before, after []interface{}) []string {
+	changed := []string{}
+	for idx, col := range table.Columns {
+		if idx >= len(before) || idx >= len(after) {
+			break
+		}
+		if !reflect.DeepEqual(before[idx], after[idx]) {
+			changed = append(changed, col.Name)
+		}
+	}
+	return changed
+}
+
+// isUpdateConcerned 变更的列全部属于忽略列时，该 update 不需要同步
+func (c *Canal) isUpdateConcerned(table *schema.Table, changed []string) bool {
+	if len(c.ignoreColumns) == 0 {
+		return true
+	}
+	for _, col := range changed {
+		fullName := fmt.Sprintf("%s.%s.%s", table.Schema, table.Name, col)
+		ignored := false
+		for _, regex := range c.ignoreColumns {
+			if regex.MatchString(fullName) {
+				ignored = true
+				break
+			}
+		}
+		if !ignored {
+			return true
+		}
 	}
-	return err
+	return false
+}
+
+// rowKey 由表名和主键值组成分区键，没有主键的表整表顺序处理
+func rowKey(table *schema.Table, row []interface{}) string {
+	fullName := fmt.Sprintf("%s.%s", table.Schema, table.Name)
+	if len(table.PKColumns) == 0 {
+		return fullName
+	}
+	values := make([]string, len(table.PKColumns))
+	for i, idx := range table.PKColumns {
+		if idx < len(row) {
+			values[i] = fmt.Sprint(row[idx])
+		}
+	}
+	return fmt.Sprintf("%s:%s", fullName, strings.Join(values, ","))
+}
+
+// savePos 在此位置之前发出的事件全部被输出确认后才真正保存位置
+func (c *Canal) savePos(pos mysql.Position) error {
+	c.checkpoint.Commit(func() error {
+		c.logger.Info().
+			Str(logs.Canal, c.cfg.Addr).
+			Any("pos", fmt.Sprintf("%s.%d", pos.Name, pos.Pos)).Msg("save pos")
+		err := c.posSaver.Save(c.runContext(), pos)
+		if err != nil {
+			c.logger.Error().Err(err).Msg("save pos failed")
+		}
+		return err
+	})
+	return nil
+}
+
+// saveGTID 在此之前发出的事件全部被输出确认后才真正保存 GTIDSet
+func (c *Canal) saveGTID(set mysql.GTIDSet) {
+	gtid := set.String()
+	c.checkpoint.Commit(func() error {
+		c.logger.Info().Str(logs.Canal, c.cfg.Addr).Str("gtid", gtid).Msg("save gtid")
+		err := c.posSaver.SaveGTID(c.runContext(), gtid)
+		if err != nil {
+			c.logger.Error().Err(err).Msg("save gtid failed")
+		}
+		return err
+	})
 }
 
 func (c *Canal) ResyncTables(ctx context.Context, tables []string) (bool, error) {
@@ -433,7 +797,10 @@ func (c *Canal) ResyncTables(ctx context.Context, tables []string) (bool, error)
 		}
 	}
 	if len(syncTables) > 0 {
-		go c.syncFullData(ctx, syncTables)
+		if atomic.LoadInt32(&c.snapshotting) == 1 {
+			return true, errors.New("full sync is already running")
+		}
+		go c.resync(ctx, syncTables)
 		return true, nil
 	}
 	return false, nil
@@ -453,7 +820,10 @@ func (c *Canal) ResyncTablesRegx(ctx context.Context, tables []string) (bool, er
 		}
 	}
 	if len(syncTables) > 0 {
-		go c.syncFullData(ctx, syncTables)
+		if atomic.LoadInt32(&c.snapshotting) == 1 {
+			return true, errors.New("full sync is already running")
+		}
+		go c.resync(ctx, syncTables)
 		return true, nil
 	}
 	return false, nil
@@ -462,6 +832,10 @@ func (c *Canal) ResyncTablesRegx(ctx context.Context, tables []string) (bool, er
 var _posfchanged = 0
 
 func (c *Canal) monitoring() {
+	if c.cfg.GTIDMode {
+		c.monitoringGTID()
+		return
+	}
 	pos, err := c.posSaver.Get()
 	if err != nil {
 		c.stream.Err <- fmt.Errorf("canal monitoring get cache pos failed:%s", err)
@@ -483,3 +857,61 @@ func (c *Canal) monitoring() {
 		c.stream.Err <- fmt.Errorf("canal monitoring sync delay,  cached pos(%v) now pos(%v)", pos, nowPos)
 	}
 }
+
+// monitoringGTID GTID 模式下保存的是 GTIDSet，按当前 GTIDSet 中未同步的事务数判断延迟
+func (c *Canal) monitoringGTID() {
+	raw, err := c.posSaver.GetGTID()
+	if err != nil {
+		c.stream.Err <- fmt.Errorf("canal monitoring get cache gtid failed:%s", err)
+		return
+	}
+	_, nowRaw, err := c.queryBinlogPosition(context.Background(), c.db)
+	if err != nil {
+		c.stream.Err <- fmt.Errorf("canal monitoring get now gtid failed:%s", err)
+		return
+	}
+	saved, err := mysql.ParseGTIDSet(c.cfg.Flavor, raw)
+	if err != nil {
+		c.stream.Err <- fmt.Errorf("canal monitoring parse cache gtid %s failed:%s", raw, err)
+		return
+	}
+	now, err := mysql.ParseGTIDSet(c.cfg.Flavor, nowRaw)
+	if err != nil {
+		c.stream.Err <- fmt.Errorf("canal monitoring parse now gtid %s failed:%s", nowRaw, err)
+		return
+	}
+	if delay := gtidDelay(saved, now); delay > int64(c.cfg.DelayGTID) {
+		c.stream.Err <- fmt.Errorf("canal monitoring sync delay %d transactions, cached gtid(%s) now gtid(%s)", delay, raw, nowRaw)
+	}
+}
+
+// gtidDelay 计算 now 中还没有同步到 saved 的事务数
+func gtidDelay(saved, now mysql.GTIDSet) int64 {
+	var delay int64
+	switch now := now.(type) {
+	case *mysql.MysqlGTIDSet:
+		pending := now.Clone().(*mysql.MysqlGTIDSet)
+		if saved, ok := saved.(*mysql.MysqlGTIDSet); ok {
+			pending.Minus(*saved)
+		}
+		for _, set := range pending.Sets {
+			for _, interval := range set.Intervals {
+				delay += interval.Stop - interval.Start
+			}
+		}
+	case *mysql.MariadbGTIDSet:
+		saved, _ := saved.(*mysql.MariadbGTIDSet)
+		for domain, servers := range now.Sets {
+			for server, gtid := range servers {
+				var synced uint64
+				if saved != nil && saved.Sets[domain][server] != nil {
+					synced = saved.Sets[domain][server].SequenceNumber
+				}
+				if gtid.SequenceNumber > synced {
+					delay += int64(gtid.SequenceNumber - synced)
+				}
+			}
+		}
+	}
+	return delay
+}
@@ -0,0 +1,90 @@
+package canal
+
+import (
+	"regexp"
+	"testing"
+
+	"github.com/go-mysql-org/go-mysql/canal"
+	"github.com/go-mysql-org/go-mysql/schema"
+	"github.com/longbridgeapp/assert"
+)
+
+func testTable(pks ...int) *schema.Table {
+	return &schema.Table{
+		Schema:    "shop",
+		Name:      "orders",
+		Columns:   []schema.TableColumn{{Name: "id"}, {Name: "tenant"}, {Name: "amount"}, {Name: "updated_at"}},
+		PKColumns: pks,
+	}
+}
+
+func TestRowKey(t *testing.T) {
+	cases := []struct {
+		name  string
+		table *schema.Table
+		row   []interface{}
+		key   string
+	}{
+		{"single pk", testTable(0), []interface{}{int64(7), "t1", 10, nil}, "shop.orders:7"},
+		{"composite pk", testTable(1, 0), []interface{}{int64(7), "t1", 10, nil}, "shop.orders:t1,7"},
+		{"no pk", testTable(), []interface{}{int64(7), "t1", 10, nil}, "shop.orders"},
+		{"short row", testTable(0, 5), []interface{}{int64(7)}, "shop.orders:7,"},
+	}
+	for _, c := range cases {
+		assert.Equal(t, c.key, rowKey(c.table, c.row), c.name)
+	}
+}
+
+func TestToRowChanges(t *testing.T) {
+	rows := [][]interface{}{{1, "a"}, {1, "b"}, {2, "c"}, {2, "d"}}
+	changes := toRowChanges(canal.UpdateAction, rows)
+	assert.Equal(t, 2, len(changes))
+	assert.Equal(t, []interface{}{1, "a"}, changes[0].before)
+	assert.Equal(t, []interface{}{1, "b"}, changes[0].after)
+	assert.Equal(t, []interface{}{2, "d"}, changes[1].after)
+
+	// 不成对的最后一行被丢弃
+	assert.Equal(t, 1, len(toRowChanges(canal.UpdateAction, rows[:3])))
+
+	changes = toRowChanges(canal.InsertAction, rows[:2])
+	assert.Equal(t, 2, len(changes))
+	assert.Nil(t, changes[0].before)
+	assert.Equal(t, []interface{}{1, "b"}, changes[1].after)
+}
+
+func TestChangedColumns(t *testing.T) {
+	table := testTable(0)
+	cases := []struct {
+		before, after []interface{}
+		changed       []string
+	}{
+		{[]interface{}{1, "t1", 10, "2024"}, []interface{}{1, "t1", 10, "2024"}, []string{}},
+		{[]interface{}{1, "t1", 10, "2024"}, []interface{}{1, "t1", 12, "2025"}, []string{"amount", "updated_at"}},
+		{[]interface{}{1, []byte("a"), 10}, []interface{}{2, []byte("a"), 10}, []string{"id"}},
+		// 行比列少时只比较已有的列
+		{[]interface{}{1, "t1"}, []interface{}{1, "t2", 10}, []string{"tenant"}},
+	}
+	for _, c := range cases {
+		assert.Equal(t, c.changed, changedColumns(table, c.before, c.after), c)
+	}
+}
+
+func TestIsUpdateConcerned(t *testing.T) {
+	table := testTable(0)
+	c := &Canal{}
+	assert.True(t, c.isUpdateConcerned(table, []string{"updated_at"}))
+
+	c.ignoreColumns = []*regexp.Regexp{regexp.MustCompile(`^shop\.orders\.updated_at$`), regexp.MustCompile(`\.version$`)}
+	cases := []struct {
+		changed   []string
+		concerned bool
+	}{
+		{[]string{"updated_at"}, false},
+		{[]string{"updated_at", "version"}, false},
+		{[]string{"updated_at", "amount"}, true},
+		{[]string{}, false},
+	}
+	for _, item := range cases {
+		assert.Equal(t, item.concerned, c.isUpdateConcerned(table, item.changed), item.changed)
+	}
+}
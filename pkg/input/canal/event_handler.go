@@ -2,6 +2,9 @@ package canal
 
 import (
 	"context"
+	"fmt"
+	"go-data-flow/pkg/handler"
+	"go-data-flow/pkg/stream"
 
 	"github.com/go-mysql-org/go-mysql/canal"
 	"github.com/go-mysql-org/go-mysql/mysql"
@@ -10,8 +13,9 @@ import (
 )
 
 type eventHandler struct {
-	filterAction map[string]bool
-	canal        *Canal
+	filterAction  map[string]bool
+	canal         *Canal
+	changedTables [][2]string // 当前 DDL 语句影响的表
 }
 
 func NewEventHandler(canal *Canal) *eventHandler {
@@ -43,15 +47,29 @@ func (h *eventHandler) OnTableChanged(_ *replication.EventHeader, schema, table
 		Msg("Handling Table Changed Event")
 
 	h.canal.OnTableChanged(schema, table)
+	if h.canal.cfg.EmitDDL {
+		h.changedTables = append(h.changedTables, [2]string{schema, table})
+	}
 	return nil
 }
 
-func (h *eventHandler) OnDDL(_ *replication.EventHeader, nextPos mysql.Position, _ *replication.QueryEvent) error {
+func (h *eventHandler) OnDDL(header *replication.EventHeader, nextPos mysql.Position, e *replication.QueryEvent) error {
 	log.Debug().
 		Str("binlog_name", nextPos.Name).
 		Uint32("position", nextPos.Pos).
 		Msg("Handling DDL Event")
 
+	// OnTableChanged 在 OnDDL 之前针对语句中的每个表调用
+	tables := h.changedTables
+	h.changedTables = nil
+	if h.canal.cfg.EmitDDL && !h.filterAction[string(handler.DDLEvent)] {
+		for _, table := range tables {
+			meta := h.eventMeta(header, table[0], table[1], string(handler.DDLEvent))
+			if err := h.canal.OnSchemaChanged(context.Background(), meta, string(e.Query)); err != nil {
+				return err
+			}
+		}
+	}
 	return h.canal.savePos(nextPos)
 }
 
@@ -75,7 +93,8 @@ func (h *eventHandler) OnRow(e *canal.RowsEvent) error {
 	if h.filterAction[e.Action] {
 		return nil
 	}
-	return h.canal.OnEvent(context.Background(), e.Table.Schema, e.Table.Name, e.Action, e.Rows)
+	meta := h.eventMeta(e.Header, e.Table.Schema, e.Table.Name, e.Action)
+	return h.canal.OnEvent(context.Background(), meta, e.Rows)
 }
 
 func (h *eventHandler) OnGTID(_ *replication.EventHeader, gtid mysql.BinlogGTIDEvent) error {
@@ -84,10 +103,27 @@ func (h *eventHandler) OnGTID(_ *replication.EventHeader, gtid mysql.BinlogGTIDE
 }
 
 func (h *eventHandler) OnPosSynced(_ *replication.EventHeader, pos mysql.Position, set mysql.GTIDSet, force bool) error {
-	// log.Info().Msg("PosSynced Event Triggered (no-op)")
+	if !h.canal.cfg.GTIDMode || set == nil {
+		return nil
+	}
+	log.Debug().
+		Str("gtid", set.String()).
+		Msg("Handling PosSynced Event")
+
+	h.canal.saveGTID(set)
 	return nil
 }
 
+// eventMeta 构造 binlog 事件的元数据
+func (h *eventHandler) eventMeta(header *replication.EventHeader, schema, table, action string) stream.Meta {
+	meta := stream.Meta{Source: h.canal.cfg.Addr, Schema: schema, Table: table, Action: action}
+	if header != nil {
+		meta.Timestamp = int64(header.Timestamp)
+		meta.Position = fmt.Sprintf("%s:%d", h.canal.cli.SyncedPosition().Name, header.LogPos)
+	}
+	return meta
+}
+
 func (h *eventHandler) String() string {
 	return "BinlogEventHandler"
 }
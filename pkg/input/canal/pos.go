@@ -1,85 +1,177 @@
 package canal
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"go-data-flow/pkg/logs"
+	"go-data-flow/pkg/redis"
+	"go-data-flow/pkg/stream"
+	"sync"
 	"time"
 
 	"github.com/go-mysql-org/go-mysql/mysql"
-	"github.com/go-redis/redis"
 	"github.com/rs/zerolog/log"
 )
 
+// PosSaver 保存时从 ctx 中取防护令牌，令牌比已保存的小时返回 ErrStaleToken
 type PosSaver interface {
-	Save(mysql.Position) error
+	Save(context.Context, mysql.Position) error
 	Get() (mysql.Position, error)
+	// SaveGTID 保存 GTID 模式下已同步的 GTIDSet
+	SaveGTID(context.Context, string) error
+	// GetGTID 获取已同步的 GTIDSet，没有时返回空字符串
+	GetGTID() (string, error)
+	// SaveSnapshot 保存全量同步进度
+	SaveSnapshot(context.Context, *SnapshotProgress) error
+	// GetSnapshot 获取全量同步进度，没有时返回 nil
+	GetSnapshot() (*SnapshotProgress, error)
 }
 
-type RedisPosSaver struct {
-	rdb        *redis.Client
-	keyPrefix  string
-	id         string
-	lastSaveAt time.Time
-	dip        int
+// SnapshotProgress 全量同步进度，进程重启后从中断的表和主键处继续
+type SnapshotProgress struct {
+	Position mysql.Position            `json:"position"` // 开始全量同步时的 binlog 位置
+	GTIDSet  string                    `json:"gtid_set,omitempty"`
+	Tables   map[string]*TableProgress `json:"tables"`
+	StartAt  time.Time                 `json:"start_at"`
+	Done     bool                      `json:"done"`
+}
+
+type TableProgress struct {
+	LastPK []PKValue `json:"last_pk,omitempty"` // 已同步的最后一行主键
+	Offset int       `json:"offset,omitempty"`  // 没有主键的表按 offset 记录
+	Rows   int64     `json:"rows"`
+	Done   bool      `json:"done"`
 }
 
-func NewRedisPosSaver(rdb *redis.Client, keyPrefix string, id string) *RedisPosSaver {
-	return &RedisPosSaver{
-		rdb:       rdb,
-		keyPrefix: keyPrefix,
-		id:        id,
+func (p *SnapshotProgress) clone() *SnapshotProgress {
+	ret := *p
+	ret.Tables = make(map[string]*TableProgress, len(p.Tables))
+	for name, table := range p.Tables {
+		item := *table
+		item.LastPK = append([]PKValue(nil), table.LastPK...)
+		ret.Tables[name] = &item
 	}
+	return &ret
 }
 
-func (s *RedisPosSaver) key() string {
-	return fmt.Sprintf("%s:binlog_position:%s", s.keyPrefix, s.id)
+// PosStoreConfig 位置存储配置，只能配置一种，都未配置时有 redis 配置使用 Redis，否则使用本地文件
+type PosStoreConfig struct {
+	File  *FilePosStoreConfig  `yaml:"file"`
+	MySQL *MySQLPosStoreConfig `yaml:"mysql"`
+	Redis *RedisPosStoreConfig `yaml:"redis"`
 }
 
-func (s *RedisPosSaver) Save(pos mysql.Position) error {
-	const saveInterval = 5 // seconds
-	const maxDip = 10
+// NewPosSaver 根据配置创建位置存储，使用 canal 地址区分不同的同步实例
+func NewPosSaver(canalCfg *Config) (PosSaver, error) {
+	cfg, id := canalCfg.PosStore, canalCfg.Addr
+	var store posStore
+	var err error
+	switch {
+	case cfg.File != nil:
+		store, err = newFilePosStore(cfg.File, id)
+	case cfg.MySQL != nil:
+		store, err = newMySQLPosStore(cfg.MySQL, canalCfg)
+	case cfg.Redis != nil:
+		store, err = newRedisPosStore(cfg.Redis, id)
+	case redis.Ins != nil:
+		store, err = newRedisPosStore(&RedisPosStoreConfig{}, id)
+	default:
+		store, err = newFilePosStore(&FilePosStoreConfig{}, id)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &posSaver{store: store}, nil
+}
 
-	now := time.Now()
-	s.dip++
+const (
+	posKey      = "binlog_position"
+	gtidKey     = "gtid"
+	snapshotKey = "snapshot"
+)
+
+// ErrStaleToken 位置已被持有更新租约的 leader 保存过，当前实例已失去 leader 身份
+var ErrStaleToken = errors.New("stale fencing token, position saved by a newer leader")
+
+// posStore 位置存储后端，按名称保存原始数据
+type posStore interface {
+	// Set 与数据一起保存防护令牌，token 小于已保存的令牌时返回 ErrStaleToken，
+	// token 为 0 表示没有启用选主，不做检查
+	Set(name string, value []byte, token int64) error
+	// Get 不存在时返回 nil
+	Get(name string) ([]byte, error)
+	// Key 用于日志展示存储位置
+	Key(name string) string
+}
 
-	if now.Sub(s.lastSaveAt) > time.Second*time.Duration(saveInterval) || s.dip > maxDip {
-		key := s.key()
-		raw, err := json.Marshal(pos)
-		if err != nil {
-			return fmt.Errorf("failed to marshal binlog position: %w", err)
-		}
+// posSaver 负责序列化和保存频率控制，具体存储由 posStore 实现
+type posSaver struct {
+	store      posStore
+	mu         sync.Mutex
+	lastSaveAt time.Time
+	dip        int
+}
 
-		log.Info().
-			Str(logs.PosSaver, key).
-			Str("binlog_name", pos.Name).
-			Uint32("binlog_pos", pos.Pos).
-			Msg("Saving binlog position")
+type forceSaveKey struct{}
 
-		if err := s.rdb.Set(key, raw, 0).Err(); err != nil {
-			return fmt.Errorf("failed to save binlog position in Redis: %w", err)
-		}
+// withForceSave 命令指定的位置必须立即保存，不受保存频率限制
+func withForceSave(ctx context.Context) context.Context {
+	return context.WithValue(ctx, forceSaveKey{}, true)
+}
+
+// due 控制保存频率，避免频繁写入存储
+func (s *posSaver) due(ctx context.Context) bool {
+	const saveInterval = 5 // seconds
+	const maxDip = 10
 
-		s.lastSaveAt = now
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.dip++
+	force, _ := ctx.Value(forceSaveKey{}).(bool)
+	if force || time.Since(s.lastSaveAt) > time.Second*time.Duration(saveInterval) || s.dip > maxDip {
+		s.lastSaveAt = time.Now()
 		s.dip = 0
+		return true
 	}
+	return false
+}
 
+func (s *posSaver) Save(ctx context.Context, pos mysql.Position) error {
+	if !s.due(ctx) {
+		return nil
+	}
+	raw, err := json.Marshal(pos)
+	if err != nil {
+		return fmt.Errorf("failed to marshal binlog position: %w", err)
+	}
+
+	log.Info().
+		Str(logs.PosSaver, s.store.Key(posKey)).
+		Str("binlog_name", pos.Name).
+		Uint32("binlog_pos", pos.Pos).
+		Msg("Saving binlog position")
+
+	if err := s.store.Set(posKey, raw, stream.FenceToken(ctx)); err != nil {
+		return fmt.Errorf("failed to save binlog position: %w", err)
+	}
 	return nil
 }
 
-func (s *RedisPosSaver) Get() (mysql.Position, error) {
-	key := s.key()
-	result, err := s.rdb.Get(key).Result()
+func (s *posSaver) Get() (mysql.Position, error) {
+	key := s.store.Key(posKey)
+	raw, err := s.store.Get(posKey)
 	if err != nil {
-		if err == redis.Nil {
-			log.Warn().Str(logs.PosSaver, key).Msg("No binlog position found, starting from scratch")
-			return mysql.Position{}, nil
-		}
-		return mysql.Position{}, fmt.Errorf("failed to get binlog position from Redis: %w", err)
+		return mysql.Position{}, fmt.Errorf("failed to get binlog position: %w", err)
+	}
+	if raw == nil {
+		log.Warn().Str(logs.PosSaver, key).Msg("No binlog position found, starting from scratch")
+		return mysql.Position{}, nil
 	}
 
 	var pos mysql.Position
-	if err := json.Unmarshal([]byte(result), &pos); err != nil {
+	if err := json.Unmarshal(raw, &pos); err != nil {
 		return mysql.Position{}, fmt.Errorf("failed to unmarshal binlog position: %w", err)
 	}
 
@@ -91,3 +183,52 @@ func (s *RedisPosSaver) Get() (mysql.Position, error) {
 
 	return pos, nil
 }
+
+func (s *posSaver) SaveGTID(ctx context.Context, gtid string) error {
+	if !s.due(ctx) {
+		return nil
+	}
+	log.Info().Str(logs.PosSaver, s.store.Key(gtidKey)).Str("gtid", gtid).Msg("Saving gtid set")
+	if err := s.store.Set(gtidKey, []byte(gtid), stream.FenceToken(ctx)); err != nil {
+		return fmt.Errorf("failed to save gtid set: %w", err)
+	}
+	return nil
+}
+
+func (s *posSaver) GetGTID() (string, error) {
+	raw, err := s.store.Get(gtidKey)
+	if err != nil {
+		return "", fmt.Errorf("failed to get gtid set: %w", err)
+	}
+	if raw == nil {
+		log.Warn().Str(logs.PosSaver, s.store.Key(gtidKey)).Msg("No gtid set found, starting from scratch")
+		return "", nil
+	}
+	return string(raw), nil
+}
+
+func (s *posSaver) SaveSnapshot(ctx context.Context, progress *SnapshotProgress) error {
+	raw, err := json.Marshal(progress)
+	if err != nil {
+		return fmt.Errorf("failed to marshal snapshot progress: %w", err)
+	}
+	if err := s.store.Set(snapshotKey, raw, stream.FenceToken(ctx)); err != nil {
+		return fmt.Errorf("failed to save snapshot progress: %w", err)
+	}
+	return nil
+}
+
+func (s *posSaver) GetSnapshot() (*SnapshotProgress, error) {
+	raw, err := s.store.Get(snapshotKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get snapshot progress: %w", err)
+	}
+	if raw == nil {
+		return nil, nil
+	}
+	progress := &SnapshotProgress{}
+	if err := json.Unmarshal(raw, progress); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal snapshot progress: %w", err)
+	}
+	return progress, nil
+}
@@ -0,0 +1,113 @@
+package canal
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+type FilePosStoreConfig struct {
+	Dir string `yaml:"dir"`
+}
+
+// filePosStore 本地文件存储，写入临时文件 fsync 后 rename，保证文件内容完整
+type filePosStore struct {
+	dir string
+	id  string
+	mu  sync.Mutex
+}
+
+var unsafeFileChars = regexp.MustCompile(`[^A-Za-z0-9._-]`)
+
+func newFilePosStore(cfg *FilePosStoreConfig, id string) (*filePosStore, error) {
+	dir := cfg.Dir
+	if dir == "" {
+		dir = "data"
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("create pos store dir %s failed: %w", dir, err)
+	}
+	return &filePosStore{dir: dir, id: unsafeFileChars.ReplaceAllString(id, "_")}, nil
+}
+
+func (s *filePosStore) Key(name string) string {
+	return filepath.Join(s.dir, fmt.Sprintf("%s.%s", s.id, name))
+}
+
+// Set 令牌保存在 <key>.token 文件中，令牌不小于已保存的令牌时才写入
+func (s *filePosStore) Set(name string, value []byte, token int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	path := s.Key(name)
+	if token != 0 {
+		saved, err := s.token(path)
+		if err != nil {
+			return err
+		}
+		if token < saved {
+			return ErrStaleToken
+		}
+	}
+	if err := s.write(path, value); err != nil {
+		return err
+	}
+	if token == 0 {
+		return nil
+	}
+	return s.write(path+".token", []byte(strconv.FormatInt(token, 10)))
+}
+
+func (s *filePosStore) token(path string) (int64, error) {
+	raw, err := os.ReadFile(path + ".token")
+	if os.IsNotExist(err) {
+		return 0, nil
+	} else if err != nil {
+		return 0, err
+	}
+	return strconv.ParseInt(strings.TrimSpace(string(raw)), 10, 64)
+}
+
+// write 写入临时文件 fsync 后 rename
+func (s *filePosStore) write(path string, value []byte) error {
+	tmp := path + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err = f.Write(value); err == nil {
+		err = f.Sync()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	if err = os.Rename(tmp, path); err != nil {
+		return err
+	}
+	// rename 之后同步目录，保证掉电后文件名指向新内容
+	dir, err := os.Open(s.dir)
+	if err != nil {
+		return err
+	}
+	defer dir.Close()
+	return dir.Sync()
+}
+
+func (s *filePosStore) Get(name string) ([]byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	raw, err := os.ReadFile(s.Key(name))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	return raw, err
+}
@@ -0,0 +1,100 @@
+package canal
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+)
+
+// MySQLPosStoreConfig 将位置保存在 MySQL 表中。
+// 未配置 dsn 时使用 canal 的连接信息和 database，注意不要让 include_table_regex 匹配到位置表
+type MySQLPosStoreConfig struct {
+	DSN      string `yaml:"dsn"`
+	Database string `yaml:"database"`
+	Table    string `yaml:"table"`
+}
+
+type mysqlPosStore struct {
+	db    *sql.DB
+	table string
+	id    string
+}
+
+func newMySQLPosStore(cfg *MySQLPosStoreConfig, canalCfg *Config) (*mysqlPosStore, error) {
+	dsn := cfg.DSN
+	if dsn == "" {
+		if cfg.Database == "" {
+			return nil, errors.New("mysql pos store must have dsn or database setting")
+		}
+		dsn = fmt.Sprintf("%s:%s@tcp(%s)/%s", canalCfg.User, canalCfg.Password, canalCfg.Addr, cfg.Database)
+	}
+	table := cfg.Table
+	if table == "" {
+		table = "data_flow_position"
+	}
+	db, err := sql.Open("mysql", dsn)
+	if err != nil {
+		return nil, err
+	}
+	store := &mysqlPosStore{db: db, table: quoteName(table), id: canalCfg.Addr}
+	_, err = db.Exec(fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s (
+		id VARCHAR(255) NOT NULL,
+		name VARCHAR(64) NOT NULL,
+		value MEDIUMBLOB NOT NULL,
+		token BIGINT NOT NULL DEFAULT 0,
+		updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+		PRIMARY KEY (id, name)
+	)`, store.table))
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("create pos store table %s failed: %w", table, err)
+	}
+	// 兼容没有 token 列的旧表
+	if _, err = db.Exec(fmt.Sprintf("SELECT token FROM %s LIMIT 0", store.table)); err != nil {
+		if _, err = db.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN token BIGINT NOT NULL DEFAULT 0 AFTER value", store.table)); err != nil {
+			db.Close()
+			return nil, fmt.Errorf("add token column to pos store table %s failed: %w", table, err)
+		}
+	}
+	return store, nil
+}
+
+func (s *mysqlPosStore) Key(name string) string {
+	return fmt.Sprintf("%s(%s,%s)", s.table, s.id, name)
+}
+
+// Set 只更新令牌不大于 token 的行，没有更新时区分是新行、内容没有变化还是令牌已过期
+func (s *mysqlPosStore) Set(name string, value []byte, token int64) error {
+	result, err := s.db.Exec(fmt.Sprintf("UPDATE %s SET value = ?, token = GREATEST(token, ?) WHERE id = ? AND name = ? AND (? = 0 OR token <= ?)", s.table),
+		value, token, s.id, name, token, token)
+	if err != nil {
+		return err
+	}
+	if n, err := result.RowsAffected(); err != nil || n > 0 {
+		return err
+	}
+	result, err = s.db.Exec(fmt.Sprintf("INSERT IGNORE INTO %s (id, name, value, token) VALUES (?, ?, ?, ?)", s.table), s.id, name, value, token)
+	if err != nil {
+		return err
+	}
+	if n, err := result.RowsAffected(); err != nil || n > 0 {
+		return err
+	}
+	var saved int64
+	if err = s.db.QueryRow(fmt.Sprintf("SELECT token FROM %s WHERE id = ? AND name = ?", s.table), s.id, name).Scan(&saved); err != nil {
+		return err
+	}
+	if token != 0 && token < saved {
+		return ErrStaleToken
+	}
+	return nil
+}
+
+func (s *mysqlPosStore) Get(name string) ([]byte, error) {
+	var value []byte
+	err := s.db.QueryRow(fmt.Sprintf("SELECT value FROM %s WHERE id = ? AND name = ?", s.table), s.id, name).Scan(&value)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return value, err
+}
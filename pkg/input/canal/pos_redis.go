@@ -0,0 +1,68 @@
+package canal
+
+import (
+	"errors"
+	"fmt"
+	rds "go-data-flow/pkg/redis"
+
+	"github.com/go-redis/redis"
+)
+
+type RedisPosStoreConfig struct {
+	KeyPrefix string `yaml:"key_prefix"`
+}
+
+type redisPosStore struct {
+	rdb       *redis.Client
+	keyPrefix string
+	id        string
+}
+
+func newRedisPosStore(cfg *RedisPosStoreConfig, id string) (*redisPosStore, error) {
+	if rds.Ins == nil {
+		return nil, errors.New("redis pos store requires redis config")
+	}
+	keyPrefix := cfg.KeyPrefix
+	if keyPrefix == "" {
+		keyPrefix = "flow"
+	}
+	return &redisPosStore{rdb: rds.Ins, keyPrefix: keyPrefix, id: id}, nil
+}
+
+func (s *redisPosStore) Key(name string) string {
+	return fmt.Sprintf("%s:%s:%s", s.keyPrefix, name, s.id)
+}
+
+// setScript 令牌保存在 <key>:token 中，令牌不小于已保存的令牌时才写入
+var setScript = redis.NewScript(`
+local token = tonumber(ARGV[2])
+if token > 0 then
+	local saved = tonumber(redis.call('GET', KEYS[2]) or '0')
+	if token < saved then
+		return 0
+	end
+	redis.call('SET', KEYS[2], ARGV[2])
+end
+redis.call('SET', KEYS[1], ARGV[1])
+return 1
+`)
+
+func (s *redisPosStore) Set(name string, value []byte, token int64) error {
+	key := s.Key(name)
+	ok, err := setScript.Run(s.rdb, []string{key, key + ":token"}, value, token).Int64()
+	if err != nil {
+		return err
+	}
+	if ok == 0 {
+		return ErrStaleToken
+	}
+	return nil
+}
+
+func (s *redisPosStore) Get(name string) ([]byte, error) {
+	result, err := s.rdb.Get(s.Key(name)).Bytes()
+	if err == redis.Nil {
+		return nil, nil
+	}
+	return result, err
+}
@@ -0,0 +1,82 @@
+package canal
+
+import (
+	"context"
+	"testing"
+
+	"go-data-flow/pkg/stream"
+
+	"github.com/go-mysql-org/go-mysql/mysql"
+	"github.com/longbridgeapp/assert"
+)
+
+func TestFilePosSaver(t *testing.T) {
+	saver, err := NewPosSaver(&Config{Addr: "127.0.0.1:3306", PosStore: PosStoreConfig{File: &FilePosStoreConfig{Dir: t.TempDir()}}})
+	assert.NoError(t, err)
+	ctx := context.Background()
+
+	pos, err := saver.Get()
+	assert.NoError(t, err)
+	assert.Equal(t, "", pos.Name)
+
+	assert.NoError(t, saver.Save(ctx, mysql.Position{Name: "mysql-bin.000003", Pos: 1024}))
+	pos, err = saver.Get()
+	assert.NoError(t, err)
+	assert.Equal(t, mysql.Position{Name: "mysql-bin.000003", Pos: 1024}, pos)
+
+	// 保存频率限制内的位置被跳过，命令指定的位置立即保存
+	assert.NoError(t, saver.Save(ctx, mysql.Position{Name: "mysql-bin.000003", Pos: 2048}))
+	assert.NoError(t, saver.Save(withForceSave(ctx), mysql.Position{Name: "mysql-bin.000004", Pos: 4}))
+	pos, err = saver.Get()
+	assert.NoError(t, err)
+	assert.Equal(t, mysql.Position{Name: "mysql-bin.000004", Pos: 4}, pos)
+
+	progress := &SnapshotProgress{Tables: map[string]*TableProgress{"shop.order": {LastPK: []PKValue{newPKValue(int64(10))}, Rows: 10}}}
+	assert.NoError(t, saver.SaveSnapshot(ctx, progress))
+	saved, err := saver.GetSnapshot()
+	assert.NoError(t, err)
+	assert.Equal(t, []PKValue{{Type: pkInt, Value: "10"}}, saved.Tables["shop.order"].LastPK)
+}
+
+type testFence struct{ token int64 }
+
+func (f *testFence) Token() int64 { return f.token }
+func (f *testFence) Check() error { return nil }
+
+func TestFilePosStoreFence(t *testing.T) {
+	store, err := newFilePosStore(&FilePosStoreConfig{Dir: t.TempDir()}, "127.0.0.1:3306")
+	assert.NoError(t, err)
+	saver := &posSaver{store: store}
+	oldLeader := stream.WithFence(context.Background(), &testFence{token: 5})
+	newLeader := stream.WithFence(context.Background(), &testFence{token: 7})
+
+	assert.NoError(t, saver.SaveGTID(oldLeader, "uuid:1-10"))
+	assert.NoError(t, store.Set(gtidKey, []byte("uuid:1-20"), 7))
+	// 失去租约的旧 leader 不能覆盖新 leader 保存的位置
+	assert.Equal(t, ErrStaleToken, store.Set(gtidKey, []byte("uuid:1-15"), 5))
+	gtid, err := saver.GetGTID()
+	assert.NoError(t, err)
+	assert.Equal(t, "uuid:1-20", gtid)
+
+	assert.NoError(t, store.Set(gtidKey, []byte("uuid:1-30"), stream.FenceToken(newLeader)))
+	// 没有启用选主时不检查令牌
+	assert.NoError(t, store.Set(gtidKey, []byte("uuid:1-40"), 0))
+	gtid, err = saver.GetGTID()
+	assert.NoError(t, err)
+	assert.Equal(t, "uuid:1-40", gtid)
+}
+
+func TestGTIDDelay(t *testing.T) {
+	saved, err := mysql.ParseGTIDSet(mysql.MySQLFlavor, "3e11fa47-71ca-11e1-9e33-c80aa9429562:1-100")
+	assert.NoError(t, err)
+	now, err := mysql.ParseGTIDSet(mysql.MySQLFlavor, "3e11fa47-71ca-11e1-9e33-c80aa9429562:1-150,4e11fa47-71ca-11e1-9e33-c80aa9429562:1-5")
+	assert.NoError(t, err)
+	assert.Equal(t, int64(55), gtidDelay(saved, now))
+	assert.Equal(t, int64(0), gtidDelay(now, saved))
+
+	saved, err = mysql.ParseGTIDSet(mysql.MariaDBFlavor, "0-1-100")
+	assert.NoError(t, err)
+	now, err = mysql.ParseGTIDSet(mysql.MariaDBFlavor, "0-1-130,1-2-7")
+	assert.NoError(t, err)
+	assert.Equal(t, int64(37), gtidDelay(saved, now))
+}
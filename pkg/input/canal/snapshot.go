@@ -0,0 +1,392 @@
+package canal
+
+import (
+	"context"
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+	"unicode/utf8"
+
+	"github.com/go-mysql-org/go-mysql/mysql"
+	"github.com/go-mysql-org/go-mysql/schema"
+)
+
+// syncFullData 在一致性快照事务中按主键分页全量同步，返回的进度中记录了开始同步时的 binlog 位置。
+// 同样的表上次同步未完成时，从记录的进度继续，位置仍使用上次开始时的位置
+func (c *Canal) syncFullData(ctx context.Context, tables map[string]*schema.Table) (*SnapshotProgress, error) {
+	if !atomic.CompareAndSwapInt32(&c.snapshotting, 0, 1) {
+		return nil, errors.New("full sync is already running")
+	}
+	defer atomic.StoreInt32(&c.snapshotting, 0)
+
+	// 全量同步时，堵塞增量同步，防止 binlog 事件丢失
+	atomic.StoreInt32(&c.isIncremental, 0)
+	defer func() {
+		atomic.StoreInt32(&c.isIncremental, 1)
+		c.incrementCond.Broadcast()
+	}()
+
+	conn, err := c.db.Conn(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get snapshot connection: %w", err)
+	}
+	defer conn.Close()
+
+	pos, gtid, err := c.startSnapshot(ctx, conn)
+	if err != nil {
+		return nil, err
+	}
+	// 快照事务只读，结束后回滚即可
+	defer conn.ExecContext(context.Background(), "ROLLBACK")
+
+	progress, err := c.resumeProgress(tables)
+	if err != nil {
+		return nil, err
+	}
+	if progress == nil {
+		progress = &SnapshotProgress{Position: pos, Tables: map[string]*TableProgress{}, GTIDSet: gtid, StartAt: time.Now()}
+		for name := range tables {
+			progress.Tables[name] = &TableProgress{}
+		}
+	} else {
+		c.logger.Info().Any("progress", progress).Msg("resume full sync")
+	}
+	c.progressMu.Lock()
+	c.progress = progress
+	c.progressMu.Unlock()
+	c.commitProgress()
+
+	throttle := newThrottle(c.cfg.FullSyncRowsPerSec)
+	for name, table := range tables {
+		if progress.Tables[name].Done {
+			continue
+		}
+		if err := c.snapshotTable(ctx, conn, table, progress.Position, progress.Tables[name], throttle); err != nil {
+			return nil, err
+		}
+	}
+	c.updateProgress(func() {
+		progress.Done = true
+	})
+	return progress.clone(), nil
+}
+
+// resumeProgress 获取可以继续的同步进度，表不一致或起始位置已失效时重新同步
+func (c *Canal) resumeProgress(tables map[string]*schema.Table) (*SnapshotProgress, error) {
+	progress, err := c.posSaver.GetSnapshot()
+	if err != nil {
+		return nil, fmt.Errorf("get snapshot progress failed: %w", err)
+	}
+	if progress == nil || progress.Done || len(progress.Tables) != len(tables) {
+		return nil, nil
+	}
+	for name := range tables {
+		if _, ok := progress.Tables[name]; !ok {
+			return nil, nil
+		}
+	}
+	ok, err := c.checkPositionAvaliable(progress.Position)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		c.logger.Warn().Any("position", progress.Position).Msg("snapshot start position not available, restart full sync")
+		return nil, nil
+	}
+	return progress, nil
+}
+
+// resumeResync 继续进程退出前未完成的重新同步
+func (c *Canal) resumeResync(ctx context.Context) error {
+	progress, err := c.posSaver.GetSnapshot()
+	if err != nil {
+		return fmt.Errorf("get snapshot progress failed: %w", err)
+	}
+	if progress == nil || progress.Done || atomic.LoadInt32(&c.snapshotting) == 1 {
+		return nil
+	}
+	tables := map[string]*schema.Table{}
+	for name := range progress.Tables {
+		if table, ok := c.tables[name]; ok {
+			tables[name] = table
+		}
+	}
+	if len(tables) > 0 {
+		go c.resync(ctx, tables)
+	}
+	return nil
+}
+
+func (c *Canal) resync(ctx context.Context, tables map[string]*schema.Table) {
+	if _, err := c.syncFullData(ctx, tables); err != nil && ctx.Err() == nil {
+		c.stream.Err <- fmt.Errorf("canal %s resync tables failed: %w", c.cfg.Addr, err)
+	}
+}
+
+func (c *Canal) updateProgress(update func()) {
+	c.progressMu.Lock()
+	update()
+	c.progressMu.Unlock()
+	c.commitProgress()
+}
+
+// commitProgress 已同步的数据全部被输出确认后才保存进度
+func (c *Canal) commitProgress() {
+	c.progressMu.Lock()
+	progress := c.progress.clone()
+	c.progressMu.Unlock()
+	c.checkpoint.Commit(func() error {
+		return c.posSaver.SaveSnapshot(c.runContext(), progress)
+	})
+}
+
+// SnapshotStatus 返回当前或最近一次全量同步的进度
+func (c *Canal) SnapshotStatus() (*SnapshotProgress, error) {
+	c.progressMu.Lock()
+	defer c.progressMu.Unlock()
+	if c.progress != nil {
+		return c.progress.clone(), nil
+	}
+	return c.posSaver.GetSnapshot()
+}
+
+// startSnapshot 开启一致性快照事务并获取对应的 binlog 位置。
+// 加锁时位置与快照完全一致；不加锁时先取位置再开启快照，两者之间的变更会在增量同步时重放
+func (c *Canal) startSnapshot(ctx context.Context, conn *sql.Conn) (pos mysql.Position, gtid string, err error) {
+	if _, err = conn.ExecContext(ctx, "SET SESSION TRANSACTION ISOLATION LEVEL REPEATABLE READ"); err != nil {
+		return pos, gtid, fmt.Errorf("failed to set snapshot isolation level: %w", err)
+	}
+	if c.cfg.FullSyncLock {
+		if _, err = conn.ExecContext(ctx, "FLUSH TABLES WITH READ LOCK"); err != nil {
+			return pos, gtid, fmt.Errorf("failed to lock tables for snapshot: %w", err)
+		}
+		defer conn.ExecContext(context.Background(), "UNLOCK TABLES")
+	} else if pos, gtid, err = c.queryBinlogPosition(ctx, conn); err != nil {
+		return pos, gtid, err
+	}
+	if _, err = conn.ExecContext(ctx, "START TRANSACTION WITH CONSISTENT SNAPSHOT"); err != nil {
+		return pos, gtid, fmt.Errorf("failed to start consistent snapshot: %w", err)
+	}
+	if c.cfg.FullSyncLock {
+		if pos, gtid, err = c.queryBinlogPosition(ctx, conn); err != nil {
+			return pos, gtid, err
+		}
+	}
+	c.logger.Info().Str("binlog_file", pos.Name).Uint32("binlog_pos", pos.Pos).Str("gtid_set", gtid).Msg("snapshot started")
+	return pos, gtid, nil
+}
+
+func (c *Canal) snapshotTable(ctx context.Context, conn *sql.Conn, table *schema.Table, pos mysql.Position, progress *TableProgress, throttle *throttle) error {
+	var lastPK []interface{}
+	for _, value := range progress.LastPK {
+		lastPK = append(lastPK, value.Arg())
+	}
+	offset := progress.Offset
+	for {
+		// 在每次分页前检查上下文是否已取消，确保可以及时响应取消请求
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+
+		query, args := pageQuery(table, lastPK, offset, c.cfg.FullSyncPageSize)
+		values, err := queryPage(ctx, conn, query, args)
+		if err != nil {
+			return fmt.Errorf("failed to sync full data from %s: %w", table.Name, err)
+		}
+		if len(values) > 0 {
+			if err = c.storeFullData(ctx, table, pos, values); err != nil {
+				return fmt.Errorf("failed to store full data for table %s: %w", table.Name, err)
+			}
+
+			c.logger.Info().
+				Str("database", table.Schema).
+				Str("table", table.Name).
+				Str("sync query", query).
+				Any("args", args).
+				Msg("Successfully synced a page of full data")
+		}
+
+		var lastPKValues []PKValue
+		if len(table.PKColumns) > 0 && len(values) > 0 {
+			last := values[len(values)-1]
+			lastPK = make([]interface{}, len(table.PKColumns))
+			lastPKValues = make([]PKValue, len(table.PKColumns))
+			for i, idx := range table.PKColumns {
+				lastPK[i] = last[idx]
+				lastPKValues[i] = newPKValue(last[idx])
+			}
+		} else {
+			offset += len(values)
+		}
+		finished := len(values) < c.cfg.FullSyncPageSize
+		c.updateProgress(func() {
+			if lastPKValues != nil {
+				progress.LastPK = lastPKValues
+			}
+			progress.Offset = offset
+			progress.Rows += int64(len(values))
+			progress.Done = finished
+		})
+		if finished {
+			return nil
+		}
+		throttle.wait(ctx, len(values))
+	}
+}
+
+// PKValue 按类型保存的主键值，继续同步时还原为与查询结果类型一致的参数，保证 MySQL 按原来的顺序比较。
+// 二进制数据用 base64 保存，时间按数据库的格式保存
+type PKValue struct {
+	Type  string `json:"type"`
+	Value string `json:"value"`
+}
+
+const (
+	pkInt    = "int"
+	pkUint   = "uint"
+	pkFloat  = "float"
+	pkString = "string"
+	pkBytes  = "bytes"
+	pkTime   = "time"
+)
+
+const pkTimeLayout = "2006-01-02 15:04:05.999999"
+
+func newPKValue(value interface{}) PKValue {
+	switch v := value.(type) {
+	case int64:
+		return PKValue{Type: pkInt, Value: strconv.FormatInt(v, 10)}
+	case int32:
+		return PKValue{Type: pkInt, Value: strconv.FormatInt(int64(v), 10)}
+	case int:
+		return PKValue{Type: pkInt, Value: strconv.Itoa(v)}
+	case uint64:
+		return PKValue{Type: pkUint, Value: strconv.FormatUint(v, 10)}
+	case uint32:
+		return PKValue{Type: pkUint, Value: strconv.FormatUint(uint64(v), 10)}
+	case float64:
+		return PKValue{Type: pkFloat, Value: strconv.FormatFloat(v, 'g', -1, 64)}
+	case float32:
+		return PKValue{Type: pkFloat, Value: strconv.FormatFloat(float64(v), 'g', -1, 32)}
+	case []byte:
+		if utf8.Valid(v) {
+			return PKValue{Type: pkString, Value: string(v)}
+		}
+		return PKValue{Type: pkBytes, Value: base64.StdEncoding.EncodeToString(v)}
+	case time.Time:
+		return PKValue{Type: pkTime, Value: v.Format(pkTimeLayout)}
+	case string:
+		return PKValue{Type: pkString, Value: v}
+	default:
+		return PKValue{Type: pkString, Value: fmt.Sprint(v)}
+	}
+}
+
+// Arg 还原为分页查询的参数，无法解析时按字符串比较
+func (v PKValue) Arg() interface{} {
+	switch v.Type {
+	case pkInt:
+		if n, err := strconv.ParseInt(v.Value, 10, 64); err == nil {
+			return n
+		}
+	case pkUint:
+		if n, err := strconv.ParseUint(v.Value, 10, 64); err == nil {
+			return n
+		}
+	case pkFloat:
+		if f, err := strconv.ParseFloat(v.Value, 64); err == nil {
+			return f
+		}
+	case pkBytes:
+		if b, err := base64.StdEncoding.DecodeString(v.Value); err == nil {
+			return b
+		}
+	}
+	return v.Value
+}
+
+// UnmarshalJSON 兼容旧版本按字符串保存的主键
+func (v *PKValue) UnmarshalJSON(data []byte) error {
+	if len(data) > 0 && data[0] == '"' {
+		v.Type = pkString
+		return json.Unmarshal(data, &v.Value)
+	}
+	type plain PKValue
+	return json.Unmarshal(data, (*plain)(v))
+}
+
+// pageQuery 有主键的表按主键范围分页，没有主键的表在快照内按 offset 分页
+func pageQuery(table *schema.Table, lastPK []interface{}, offset, limit int) (string, []interface{}) {
+	columns := make([]string, len(table.Columns))
+	for idx, col := range table.Columns {
+		columns[idx] = quoteName(col.Name)
+	}
+	from := fmt.Sprintf("SELECT %s FROM %s.%s", strings.Join(columns, ", "), quoteName(table.Schema), quoteName(table.Name))
+	if len(table.PKColumns) == 0 {
+		return fmt.Sprintf("%s LIMIT %d OFFSET %d", from, limit, offset), nil
+	}
+
+	pks := make([]string, len(table.PKColumns))
+	for i, idx := range table.PKColumns {
+		pks[i] = quoteName(table.Columns[idx].Name)
+	}
+	pk := strings.Join(pks, ", ")
+	if lastPK == nil {
+		return fmt.Sprintf("%s ORDER BY %s LIMIT %d", from, pk, limit), nil
+	}
+	placeholders := strings.TrimSuffix(strings.Repeat("?, ", len(pks)), ", ")
+	return fmt.Sprintf("%s WHERE (%s) > (%s) ORDER BY %s LIMIT %d", from, pk, placeholders, pk, limit), lastPK
+}
+
+func quoteName(name string) string {
+	return "`" + strings.ReplaceAll(name, "`", "``") + "`"
+}
+
+func queryPage(ctx context.Context, conn *sql.Conn, query string, args []interface{}) ([][]interface{}, error) {
+	rows, err := conn.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var values [][]interface{}
+	for rows.Next() {
+		rowData, err := ScanRow(rows)
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, rowData)
+	}
+	return values, rows.Err()
+}
+
+// throttle 按每秒行数限制全量同步速度，避免对数据库造成过大压力
+type throttle struct {
+	rate  int
+	start time.Time
+	rows  int
+}
+
+func newThrottle(rate int) *throttle {
+	return &throttle{rate: rate, start: time.Now()}
+}
+
+func (t *throttle) wait(ctx context.Context, rows int) {
+	if t.rate <= 0 {
+		return
+	}
+	t.rows += rows
+	expect := time.Duration(float64(t.rows) / float64(t.rate) * float64(time.Second))
+	if delay := expect - time.Since(t.start); delay > 0 {
+		select {
+		case <-ctx.Done():
+		case <-time.After(delay):
+		}
+	}
+}
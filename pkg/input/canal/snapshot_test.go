@@ -0,0 +1,67 @@
+package canal
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/go-mysql-org/go-mysql/schema"
+	"github.com/longbridgeapp/assert"
+)
+
+func TestPKValueRoundTrip(t *testing.T) {
+	created := time.Date(2024, 5, 1, 8, 30, 15, 123456000, time.UTC)
+	cases := []struct {
+		value interface{}
+		arg   interface{}
+	}{
+		{int64(-42), int64(-42)},
+		{uint64(18446744073709551615), uint64(18446744073709551615)},
+		{0.1, 0.1},
+		{[]byte("12.50"), "12.50"},
+		{[]byte{0x00, 0xff, 0x10}, []byte{0x00, 0xff, 0x10}},
+		{created, "2024-05-01 08:30:15.123456"},
+		{"order-1", "order-1"},
+	}
+	for _, c := range cases {
+		raw, err := json.Marshal(newPKValue(c.value))
+		assert.NoError(t, err)
+		var value PKValue
+		assert.NoError(t, json.Unmarshal(raw, &value))
+		assert.Equal(t, c.arg, value.Arg(), c.value)
+	}
+
+	// 旧版本按字符串保存的进度仍然可以继续
+	var progress TableProgress
+	assert.NoError(t, json.Unmarshal([]byte(`{"last_pk":["10","a"],"rows":2}`), &progress))
+	assert.Equal(t, []PKValue{{Type: pkString, Value: "10"}, {Type: pkString, Value: "a"}}, progress.LastPK)
+}
+
+func TestPageQuery(t *testing.T) {
+	table := &schema.Table{
+		Schema:    "shop",
+		Name:      "order`items",
+		Columns:   []schema.TableColumn{{Name: "order_id"}, {Name: "line"}, {Name: "sku"}},
+		PKColumns: []int{0, 1},
+	}
+	from := "SELECT `order_id`, `line`, `sku` FROM `shop`.`order``items`"
+	cases := []struct {
+		name   string
+		table  *schema.Table
+		lastPK []interface{}
+		offset int
+		query  string
+		args   []interface{}
+	}{
+		{"first page", table, nil, 0, from + " ORDER BY `order_id`, `line` LIMIT 100", nil},
+		{"composite pk", table, []interface{}{int64(10), int64(2)}, 0,
+			from + " WHERE (`order_id`, `line`) > (?, ?) ORDER BY `order_id`, `line` LIMIT 100", []interface{}{int64(10), int64(2)}},
+		{"no pk", &schema.Table{Schema: "shop", Name: "logs", Columns: []schema.TableColumn{{Name: "msg"}}}, nil, 300,
+			"SELECT `msg` FROM `shop`.`logs` LIMIT 100 OFFSET 300", nil},
+	}
+	for _, c := range cases {
+		query, args := pageQuery(c.table, c.lastPK, c.offset, 100)
+		assert.Equal(t, c.query, query, c.name)
+		assert.Equal(t, c.args, args, c.name)
+	}
+}
@@ -17,11 +17,12 @@ type Input interface {
 type BaseInput struct {
 	*util.Cancelable
 	handler.Matcher
-	commander *command.Commander
+	commander  *command.Commander
+	deadLetter stream.DeadLetterQueue // 未配置死信队列时为 nil
 }
 
-func NewInput(cancelable *util.Cancelable, cfg Config, commander *command.Commander) (Input, error) {
-	return Factory(cancelable, cfg, commander)
+func NewInput(cancelable *util.Cancelable, cfg Config, commander *command.Commander, deadLetter stream.DeadLetterQueue) (Input, error) {
+	return Factory(cancelable, cfg, commander, deadLetter)
 }
 
 func init() {
@@ -44,7 +45,7 @@ type OutputFactory func(base BaseInput, cfg interface{}) (Input, error)
 
 var factories = make(map[string]OutputFactory)
 
-func Factory(cancelable *util.Cancelable, cfg Config, commander *command.Commander) (Input, error) {
+func Factory(cancelable *util.Cancelable, cfg Config, commander *command.Commander, deadLetter stream.DeadLetterQueue) (Input, error) {
 	v := reflect.ValueOf(cfg)
 	for i := 0; i < v.NumField(); i++ {
 		field := v.Field(i)
@@ -58,7 +59,7 @@ func Factory(cancelable *util.Cancelable, cfg Config, commander *command.Command
 			if err != nil {
 				return nil, err
 			}
-			input, err := factory(BaseInput{Cancelable: cancelable, Matcher: matcher, commander: commander}, field.Interface())
+			input, err := factory(BaseInput{Cancelable: cancelable, Matcher: matcher, commander: commander, deadLetter: deadLetter}, field.Interface())
 			if err != nil {
 				return nil, err
 			}
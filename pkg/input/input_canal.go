@@ -4,7 +4,6 @@ import (
 	"context"
 	"encoding/json"
 	"go-data-flow/pkg/input/canal"
-	"go-data-flow/pkg/redis"
 	"go-data-flow/pkg/stream"
 
 	"github.com/go-mysql-org/go-mysql/mysql"
@@ -19,7 +18,11 @@ type Canal struct {
 
 func NewCanal(base BaseInput, cfg *canal.Config) (*Canal, error) {
 	stream := stream.NewSteam()
-	ins, err := canal.NewCanal(cfg, canal.NewRedisPosSaver(redis.Ins, "flow", cfg.Addr), stream)
+	posSaver, err := canal.NewPosSaver(cfg)
+	if err != nil {
+		return nil, err
+	}
+	ins, err := canal.NewCanal(cfg, posSaver, stream)
 	if err != nil {
 		return nil, err
 	}
@@ -45,6 +48,7 @@ func (c *Canal) Flow(ctx context.Context) *stream.Scream {
 func (c *Canal) registerCommand() {
 	c.commander.RegisterHandler("canal", "resync_tables", c.resyncTables)
 	c.commander.RegisterHandler("canal", "sync_from_position", c.syncFromPosition)
+	c.commander.RegisterHandler("canal", "snapshot_status", c.snapshotStatus)
 }
 
 func (c *Canal) resyncTables(req json.RawMessage) (ok bool, resp interface{}, err error) {
@@ -74,22 +78,53 @@ func (c *Canal) syncFromPosition(req json.RawMessage) (ok bool, resp interface{}
 	var request struct {
 		Addr string         `json:"addr"`
 		Pos  mysql.Position `json:"position"`
+		GTID string         `json:"gtid"`
 	}
 	json.Unmarshal(req, &request)
 	if len(request.Addr) == 0 {
 		resp = "Canal连接不能为空"
 		return true, resp, nil
 	}
-	if len(request.Pos.Name) == 0 || request.Pos.Pos == 0 {
+	if len(request.GTID) == 0 && (len(request.Pos.Name) == 0 || request.Pos.Pos == 0) {
 		resp = "binlog位置配置错误"
 		return true, resp, nil
 	}
 	if request.Addr == c.cfg.Addr {
 		ok = true
-		err = c.ins.SyncFromPos(request.Pos)
+		if len(request.GTID) > 0 {
+			var set mysql.GTIDSet
+			if set, err = mysql.ParseGTIDSet(c.cfg.Flavor, request.GTID); err != nil {
+				return
+			}
+			err = c.ins.SyncFromGTID(set)
+		} else {
+			err = c.ins.SyncFromPos(request.Pos)
+		}
 		if err == nil {
 			resp = "Command submitted successfully"
 		}
 	}
 	return
 }
+
+func (c *Canal) snapshotStatus(req json.RawMessage) (ok bool, resp interface{}, err error) {
+	var request struct {
+		Addr string `json:"addr"`
+	}
+	json.Unmarshal(req, &request)
+	if len(request.Addr) > 0 && request.Addr != c.cfg.Addr {
+		return false, nil, nil
+	}
+	progress, err := c.ins.SnapshotStatus()
+	if err != nil {
+		return true, "", err
+	}
+	if progress == nil {
+		return true, "没有全量同步记录", nil
+	}
+	raw, err := json.Marshal(progress)
+	if err != nil {
+		return true, "", err
+	}
+	return true, string(raw), nil
+}
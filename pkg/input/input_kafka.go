@@ -2,41 +2,67 @@ package input
 
 import (
 	"context"
-	"encoding/json"
+	"errors"
+	"fmt"
+	"go-data-flow/pkg/kafkaclient"
 	"go-data-flow/pkg/logs"
 	"go-data-flow/pkg/stream"
+	"go-data-flow/pkg/util/jsonpath"
+	"regexp"
+	"slices"
+	"sort"
+	"sync"
 	"time"
 
 	"github.com/rs/zerolog"
 	"github.com/rs/zerolog/log"
 	"github.com/segmentio/kafka-go"
+	"github.com/twmb/franz-go/pkg/kgo"
 
 	// gzip
 	_ "github.com/segmentio/kafka-go/gzip"
 	_ "github.com/segmentio/kafka-go/lz4"
-	"github.com/segmentio/kafka-go/sasl"
-	"github.com/segmentio/kafka-go/sasl/plain"
-	"github.com/segmentio/kafka-go/sasl/scram"
 	_ "github.com/segmentio/kafka-go/snappy"
 )
 
 type KafkaInputConfig struct {
-	Topic    string   `yaml:"topic"`
-	GroupID  string   `yaml:"group"`
-	Brokers  []string `yaml:"brokers"`
-	Latest   bool     `yaml:"latest"`
-	User     string   `yaml:"user"`
-	PassWord string   `yaml:"password"`
-	SASL     string   `yaml:"sasl"`
+	kafkaclient.Config `yaml:",inline"`
+	Topic              string   `yaml:"topic"`
+	Topics             []string `yaml:"topics"`      // 多个 topic，需要配置 group
+	TopicRegex         string   `yaml:"topic_regex"` // topic 正则，需要配置 group，非事务模式只匹配启动时已有的 topic
+	Format             string   `yaml:"format"`      // 消息格式：event（默认）、json、text、logfmt
+	GroupID            string   `yaml:"group"`
+	Latest             bool     `yaml:"latest"`
+	KeyPath            string   `yaml:"key_path"` // 分区键的 jsonpath，为空时使用消息 key
+	// 同一分区内最多合并多少条连续消息为一个事件，默认 1 不合并
+	BatchSize   int `yaml:"batch_size"`
+	BatchWaitMs int `yaml:"batch_wait_ms"` // 批次未满时最多等待的时间，默认 100 毫秒
+	// offset 异步提交的间隔，默认 1000 毫秒，小于 0 表示每个事件确认后同步提交
+	CommitIntervalMs int `yaml:"commit_interval_ms"`
+	// 事务 ID，配置后消费的 offset 与事务型 kafka 输出写入的消息在同一个事务中提交，实现 kafka 到 kafka 的精确一次。
+	// 同一个 group 的每个实例需要不同的事务 ID
+	TransactionalID string `yaml:"transactional_id"`
+	TxnMaxRecords   int    `yaml:"transaction_max_records"` // 每个事务最多处理的消息数，默认 500
 }
 
 type kafkaInput struct {
 	KafkaInputConfig
 	BaseInput
-	reader *kafka.Reader `yaml:"-"`
-	stream *stream.Scream
-	stop   bool
-	logger zerolog.Logger
+	group    *kafka.ConsumerGroup
+	dialer   *kafka.Dialer
+	consumed []string // 非事务模式消费的 topic
+	session  *kgo.GroupTransactSession
+	stream   *stream.Scream
+	emitMu   sync.Mutex
+	stop     bool
+	logger   zerolog.Logger
+
+	mu      sync.Mutex
+	flowCtx context.Context
+	cancel  context.CancelFunc // 停止当前的消费协程
+	done    chan struct{}      // 消费协程退出时关闭
+	resumed chan struct{}      // 暂停消费时创建，恢复时关闭
+	resetMu sync.Mutex
 }
 
 func NewKafkaInput(base BaseInput, kafkaCfg *KafkaInputConfig) (Input, error) {
@@ -45,71 +71,284 @@ func NewKafkaInput(base BaseInput, kafkaCfg *KafkaInputConfig) (Input, error) {
 		KafkaInputConfig: *kafkaCfg,
 	}
 
-	startOffset := kafka.FirstOffset
-	if plugin.Latest {
-		startOffset = kafka.LastOffset
-	}
-	cfg := kafka.ReaderConfig{Brokers: plugin.Brokers, GroupID: plugin.GroupID, Topic: plugin.Topic, StartOffset: startOffset}
-	if plugin.User != "" {
-		var mechanism sasl.Mechanism
-		var err error
-		switch plugin.SASL {
-		case "plain":
-			mechanism = plain.Mechanism{Username: plugin.User, Password: plugin.PassWord}
-		case "sha512":
-			mechanism, err = scram.Mechanism(scram.SHA512, plugin.User, plugin.PassWord)
-		case "sha256":
-			mechanism, err = scram.Mechanism(scram.SHA256, plugin.User, plugin.PassWord)
-		}
-		if err != nil {
-			return nil, err
-		}
-		cfg.Dialer = &kafka.Dialer{
-			Timeout:       10 * time.Second,
-			DualStack:     true,
-			SASLMechanism: mechanism,
-		}
+	if err := plugin.Validate(); err != nil {
+		return nil, err
+	}
+	if plugin.Format == "" {
+		plugin.Format = FormatEvent
+	}
+	if !validFormat(plugin.Format) {
+		return nil, fmt.Errorf("unknown kafka message format %s", plugin.Format)
+	}
+	if plugin.Topic != "" {
+		plugin.Topics = append([]string{plugin.Topic}, plugin.Topics...)
+	}
+	if len(plugin.Topics) == 0 && plugin.TopicRegex == "" {
+		return nil, errors.New("kafka input must have topic, topics or topic_regex setting")
+	}
+	if (len(plugin.Topics) > 1 || plugin.TopicRegex != "") && plugin.GroupID == "" {
+		return nil, errors.New("kafka input with multiple topics must have group setting")
+	}
+	if plugin.BatchSize <= 0 {
+		plugin.BatchSize = 1
+	}
+	if plugin.BatchWaitMs <= 0 {
+		plugin.BatchWaitMs = 100
+	}
+	if plugin.CommitIntervalMs == 0 {
+		plugin.CommitIntervalMs = 1000
 	}
 	plugin.logger = log.With().Any(logs.Input, "Kafka").Logger()
-	plugin.reader = kafka.NewReader(cfg)
 	plugin.stream = stream.NewSteam()
+	if err := plugin.connect(); err != nil {
+		return nil, err
+	}
+	plugin.registerCommand()
 	return plugin, nil
 }
 
+// connect 事务模式创建 franz-go 的事务会话，否则有 group 时加入 kafka-go 的消费组，
+// 没有 group 时直接消费 topic 的所有分区
+func (k *kafkaInput) connect() error {
+	if k.TransactionalID != "" {
+		session, err := k.newSession()
+		if err != nil {
+			return err
+		}
+		k.session = session
+		return nil
+	}
+	dialer, err := k.Dialer()
+	if err != nil {
+		return err
+	}
+	topics, err := k.resolveTopics()
+	if err != nil {
+		return err
+	}
+	k.dialer, k.consumed = dialer, topics
+	k.logger.Info().Strs("topics", topics).Str("format", k.Format).Msg("consume")
+	if k.GroupID == "" {
+		return nil
+	}
+	group, err := kafka.NewConsumerGroup(kafka.ConsumerGroupConfig{
+		ID:          k.GroupID,
+		Brokers:     k.Brokers,
+		Dialer:      dialer,
+		Topics:      topics,
+		StartOffset: k.startOffset(),
+	})
+	if err != nil {
+		return fmt.Errorf("create kafka consumer group failed: %w", err)
+	}
+	k.group = group
+	return nil
+}
+
+func (k *kafkaInput) startOffset() int64 {
+	if k.Latest {
+		return kafka.LastOffset
+	}
+	return kafka.FirstOffset
+}
+
 func (k *kafkaInput) Flow(ctx context.Context) *stream.Scream {
 	go func() {
 		<-k.Context().Done()
 		k.logger.Info().Msg("stopping!")
 		k.stop = true
 	}()
+	k.start(ctx)
+	return k.stream
+}
+
+// start 启动消费协程，重置 offset 时先停止消费协程，提交新的 offset 后重新启动
+func (k *kafkaInput) start(ctx context.Context) {
+	consumeCtx, cancel := context.WithCancel(ctx)
+	done := make(chan struct{})
+	k.mu.Lock()
+	k.flowCtx, k.cancel, k.done = ctx, cancel, done
+	k.mu.Unlock()
 	go func() {
-		defer func() {
-			k.logger.Info().Msg("stoped!")
-		}()
-		for !k.stop {
-			msg, err := k.reader.ReadMessage(ctx)
-			k.logger.Info().Str("topic", msg.Topic).Any("partition", msg.Partition).Any("offset", msg.Offset).Err(err).Msg("read")
-			if err == context.Canceled {
-				return
-			} else if err != nil {
-				k.stream.Err <- err
-				return
-			}
-			event := stream.Event{Context: ctx}
-			if err = json.Unmarshal(msg.Value, &event); err != nil {
-				k.logger.Info().Str("topic", msg.Topic).Any("partition", msg.Partition).Any("offset", msg.Offset).Any("raw data", string(msg.Value)).Err(err).Msg("unmarshal failed")
-				k.stream.Err <- err
-			} else {
-				k.stream.In <- event
-				result := <-k.stream.Out
-				if result.Error != nil {
-					k.logger.Info().Str("topic", msg.Topic).Any("partition", msg.Partition).Any("offset", msg.Offset).Any("raw data", string(msg.Value)).Err(err).Msg("process error")
-					k.stream.Err <- result.Error
-				} else {
-					k.reader.CommitMessages(ctx, msg)
-				}
-			}
+		defer close(done)
+		if k.session != nil {
+			k.transact(consumeCtx)
+		} else {
+			k.consume(consumeCtx)
 		}
 	}()
-	return k.stream
+}
+
+// halt 停止消费协程并等待退出，退出时会离开消费组或关闭事务会话。
+// 返回停止前使用的 ctx，消费协程没有启动时返回 nil
+func (k *kafkaInput) halt() context.Context {
+	k.mu.Lock()
+	ctx, cancel, done := k.flowCtx, k.cancel, k.done
+	k.mu.Unlock()
+	if cancel == nil {
+		return nil
+	}
+	cancel()
+	<-done
+	return ctx
+}
+
+// consume 每个 generation 为分配到的每个分区启动独立的协程读取，分区之间互不阻塞。
+// 退出时离开消费组，rebalance 时等待所有分区协程退出并提交 offset 后再加入新的 generation
+func (k *kafkaInput) consume(ctx context.Context) {
+	defer k.logger.Info().Msg("stoped!")
+	if k.group == nil {
+		assignments, err := k.assignments(ctx)
+		if err != nil {
+			k.stream.Err <- err
+			return
+		}
+		k.consumeAssignments(ctx, assignments, nil)
+		return
+	}
+	defer k.group.Close()
+	for !k.stop {
+		gen, err := k.group.Next(ctx)
+		if ctx.Err() != nil || errors.Is(err, kafka.ErrGroupClosed) {
+			return
+		} else if err != nil {
+			// 加入消费组失败时 kafka-go 会退避后重试
+			k.stream.Err <- fmt.Errorf("join kafka group %s failed: %w", k.GroupID, err)
+			continue
+		}
+		k.logger.Info().Int32("generation", gen.ID).Any("assignments", gen.Assignments).Msg("joined")
+		committer := k.newCommitter(gen.CommitOffsets)
+		gen.Start(func(genCtx context.Context) {
+			// generation 的 ctx 不带流程 ctx 中的防护令牌，只用来感知 generation 结束
+			ctx, cancel := context.WithCancel(ctx)
+			defer cancel()
+			defer context.AfterFunc(genCtx, cancel)()
+			k.consumeAssignments(ctx, gen.Assignments, committer)
+		})
+	}
+}
+
+// assignments 没有 group 时消费 topic 的所有分区
+func (k *kafkaInput) assignments(ctx context.Context) (map[string][]kafka.PartitionAssignment, error) {
+	client, err := k.client()
+	if err != nil {
+		return nil, err
+	}
+	resp, err := client.Metadata(ctx, &kafka.MetadataRequest{Topics: k.consumed})
+	if err != nil {
+		return nil, fmt.Errorf("get kafka metadata failed: %w", err)
+	}
+	assignments := map[string][]kafka.PartitionAssignment{}
+	for _, topic := range resp.Topics {
+		if topic.Error != nil {
+			return nil, fmt.Errorf("get kafka topic %s metadata failed: %w", topic.Name, topic.Error)
+		}
+		for _, p := range topic.Partitions {
+			assignments[topic.Name] = append(assignments[topic.Name], kafka.PartitionAssignment{ID: p.ID, Offset: k.startOffset()})
+		}
+	}
+	return assignments, nil
+}
+
+func (k *kafkaInput) consumeAssignments(ctx context.Context, assignments map[string][]kafka.PartitionAssignment, committer *kafkaCommitter) {
+	var wg sync.WaitGroup
+	for topic, partitions := range assignments {
+		for _, assignment := range partitions {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				k.consumePartition(ctx, topic, assignment.ID, assignment.Offset, committer)
+			}()
+		}
+	}
+	committer.run(ctx)
+	wg.Wait()
+	// generation 结束前提交剩余的 offset
+	if err := committer.flush(); err != nil {
+		k.logger.Warn().Err(err).Msg("commit offsets failed")
+	}
+}
+
+// resolveTopics 按正则匹配集群中已有的 topic
+func (k *kafkaInput) resolveTopics() ([]string, error) {
+	if k.TopicRegex == "" {
+		return k.Topics, nil
+	}
+	regex, err := regexp.Compile(k.TopicRegex)
+	if err != nil {
+		return nil, fmt.Errorf("invalid kafka topic regex %s: %w", k.TopicRegex, err)
+	}
+	client, err := k.client()
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	resp, err := client.Metadata(ctx, &kafka.MetadataRequest{})
+	if err != nil {
+		return nil, fmt.Errorf("list kafka topics failed: %w", err)
+	}
+	topics := append([]string{}, k.Topics...)
+	for _, topic := range resp.Topics {
+		if !topic.Internal && regex.MatchString(topic.Name) && !slices.Contains(topics, topic.Name) {
+			topics = append(topics, topic.Name)
+		}
+	}
+	if len(topics) == 0 {
+		return nil, fmt.Errorf("no kafka topic matches %s", k.TopicRegex)
+	}
+	sort.Strings(topics)
+	return topics, nil
+}
+
+func (k *kafkaInput) decode(ctx context.Context, msg kafka.Message) (stream.Event, error) {
+	event, err := decodeMessage(k.Format, msg)
+	if err != nil {
+		k.logger.Info().Str("topic", msg.Topic).Any("partition", msg.Partition).Any("offset", msg.Offset).Any("raw data", string(msg.Value)).Err(err).Msg("unmarshal failed")
+		return event, err
+	}
+	event.Context = ctx
+	event.Key = k.partitionKey(msg, &event)
+	return event, nil
+}
+
+// rejectMessage 无法解析的消息转入死信队列，没有配置死信队列时返回解析错误
+func (k *kafkaInput) rejectMessage(ctx context.Context, msg kafka.Message, err error) error {
+	if k.deadLetter == nil {
+		return err
+	}
+	letter := stream.DeadLetter{
+		Topic:  msg.Topic,
+		Key:    string(msg.Key),
+		Meta:   stream.Meta{Source: msg.Topic, Extra: messageExtra(msg, nil)},
+		Raw:    string(msg.Value),
+		Format: k.Format,
+		Error:  err.Error(),
+		Stage:  "input.kafka.decode",
+		Time:   time.Now(),
+	}
+	if !msg.Time.IsZero() {
+		letter.Meta.Timestamp = msg.Time.Unix()
+	}
+	if err = k.deadLetter.Write(ctx, letter); err != nil {
+		return fmt.Errorf("write dead letter failed: %w", err)
+	}
+	return nil
+}
+
+// partitionKey 从事件数据中取分区键，相同键的事件按顺序处理。
+// 没有键的消息使用所在的分区，避免被轮询分发后打乱分区内的顺序
+func (k *kafkaInput) partitionKey(msg kafka.Message, event *stream.Event) string {
+	if k.KeyPath != "" && len(event.Datas) > 0 {
+		if value := jsonpath.Get(event.Datas[0], k.KeyPath); value != nil {
+			return fmt.Sprint(value)
+		}
+	}
+	if event.Key != "" {
+		return event.Key
+	}
+	if len(msg.Key) > 0 {
+		return string(msg.Key)
+	}
+	return fmt.Sprintf("%s[%d]", msg.Topic, msg.Partition)
 }
@@ -0,0 +1,181 @@
+package input
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"go-data-flow/pkg/stream"
+
+	"github.com/segmentio/kafka-go"
+)
+
+// 消息格式
+const (
+	FormatEvent  = "event"  // stream.Event 的 JSON，默认，kafka 输出写入的格式
+	FormatJSON   = "json"   // JSON 对象作为一条数据，JSON 数组中的每个对象各为一条数据
+	FormatText   = "text"   // 整条消息作为 message 字段
+	FormatLogfmt = "logfmt" // key=value 形式的日志，没有值的 key 为 true
+)
+
+// textField text 格式中消息内容的字段名
+const textField = "message"
+
+func init() {
+	// 重放无法解析的消息时按消费时的格式重新解析
+	for _, format := range []string{FormatEvent, FormatJSON, FormatText, FormatLogfmt} {
+		stream.RegisterRawDecoder(format, func(letter stream.DeadLetter) (stream.Event, error) {
+			return decodeMessage(format, letterMessage(letter))
+		})
+	}
+}
+
+func validFormat(format string) bool {
+	switch format {
+	case FormatEvent, FormatJSON, FormatText, FormatLogfmt:
+		return true
+	}
+	return false
+}
+
+// decodeMessage 按格式将消息解析为事件，消息的 topic、分区、offset、key、消息头和时间作为元数据。
+// event 格式保留消息中的元数据，只补充 kafka 的元数据
+func decodeMessage(format string, msg kafka.Message) (stream.Event, error) {
+	event := stream.Event{}
+	switch format {
+	case FormatEvent, "":
+		if err := json.Unmarshal(msg.Value, &event); err != nil {
+			return event, err
+		}
+	default:
+		datas, err := decodePayload(format, msg.Value)
+		if err != nil {
+			return event, err
+		}
+		event.Topic = msg.Topic
+		event.Datas = datas
+		event.Meta.Source = msg.Topic
+		event.Meta.Position = fmt.Sprintf("%s[%d]@%d", msg.Topic, msg.Partition, msg.Offset)
+		if !msg.Time.IsZero() {
+			event.Meta.Timestamp = msg.Time.Unix()
+		}
+	}
+	event.Meta.Extra = messageExtra(msg, event.Meta.Extra)
+	return event, nil
+}
+
+// messageExtra 在 base 的基础上补充消息的 topic、分区、offset、key 和消息头
+func messageExtra(msg kafka.Message, base map[string]string) map[string]string {
+	extra := make(map[string]string, len(base)+4+len(msg.Headers))
+	for name, value := range base {
+		extra[name] = value
+	}
+	extra["topic"] = msg.Topic
+	extra["partition"] = strconv.Itoa(msg.Partition)
+	extra["offset"] = strconv.FormatInt(msg.Offset, 10)
+	if msg.Key != nil {
+		extra["key"] = string(msg.Key)
+	}
+	for _, header := range msg.Headers {
+		extra["header."+header.Key] = string(header.Value)
+	}
+	return extra
+}
+
+// letterMessage 从死信还原无法解析的原始消息，分区、offset、key 和消息头记录在死信的元数据中
+func letterMessage(letter stream.DeadLetter) kafka.Message {
+	msg := kafka.Message{Topic: letter.Topic, Value: []byte(letter.Raw)}
+	extra := letter.Meta.Extra
+	msg.Partition, _ = strconv.Atoi(extra["partition"])
+	msg.Offset, _ = strconv.ParseInt(extra["offset"], 10, 64)
+	if key, ok := extra["key"]; ok {
+		msg.Key = []byte(key)
+	}
+	for name, value := range extra {
+		if header, ok := strings.CutPrefix(name, "header."); ok {
+			msg.Headers = append(msg.Headers, kafka.Header{Key: header, Value: []byte(value)})
+		}
+	}
+	if letter.Meta.Timestamp != 0 {
+		msg.Time = time.Unix(letter.Meta.Timestamp, 0)
+	}
+	return msg
+}
+
+func decodePayload(format string, value []byte) ([]map[string]interface{}, error) {
+	switch format {
+	case FormatJSON:
+		value = bytes.TrimSpace(value)
+		if len(value) > 0 && value[0] == '[' {
+			datas := []map[string]interface{}{}
+			if err := json.Unmarshal(value, &datas); err != nil {
+				return nil, err
+			}
+			return datas, nil
+		}
+		data := map[string]interface{}{}
+		if err := json.Unmarshal(value, &data); err != nil {
+			return nil, err
+		}
+		return []map[string]interface{}{data}, nil
+	case FormatText:
+		if !utf8.Valid(value) {
+			return nil, errors.New("text message is not valid utf-8")
+		}
+		return []map[string]interface{}{{textField: strings.TrimRight(string(value), "\r\n")}}, nil
+	case FormatLogfmt:
+		data, err := parseLogfmt(string(value))
+		if err != nil {
+			return nil, err
+		}
+		return []map[string]interface{}{data}, nil
+	default:
+		return nil, fmt.Errorf("unknown kafka message format %s", format)
+	}
+}
+
+// parseLogfmt 解析 key=value 形式的日志，值可以用双引号包含空格和转义字符
+func parseLogfmt(line string) (map[string]interface{}, error) {
+	data := map[string]interface{}{}
+	rest := strings.TrimSpace(line)
+	for len(rest) > 0 {
+		end := strings.IndexAny(rest, "= \t")
+		if end < 0 {
+			end = len(rest)
+		}
+		key := rest[:end]
+		if key == "" {
+			return nil, fmt.Errorf("invalid logfmt %q: empty key", line)
+		}
+		rest = rest[end:]
+		if !strings.HasPrefix(rest, "=") {
+			data[key] = true
+			rest = strings.TrimLeft(rest, " \t")
+			continue
+		}
+		rest = rest[1:]
+		var value string
+		if strings.HasPrefix(rest, `"`) {
+			quoted, err := strconv.QuotedPrefix(rest)
+			if err != nil {
+				return nil, fmt.Errorf("invalid logfmt %q: unterminated quote of %s", line, key)
+			}
+			value, _ = strconv.Unquote(quoted)
+			rest = rest[len(quoted):]
+		} else {
+			end = strings.IndexAny(rest, " \t")
+			if end < 0 {
+				end = len(rest)
+			}
+			value, rest = rest[:end], rest[end:]
+		}
+		data[key] = value
+		rest = strings.TrimLeft(rest, " \t")
+	}
+	return data, nil
+}
@@ -0,0 +1,101 @@
+package input
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"go-data-flow/pkg/stream"
+
+	"github.com/longbridgeapp/assert"
+	"github.com/segmentio/kafka-go"
+)
+
+func TestParseLogfmt(t *testing.T) {
+	data, err := parseLogfmt(`level=info msg="user \"bob\" logged in" dry_run latency=12ms`)
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]interface{}{
+		"level":   "info",
+		"msg":     `user "bob" logged in`,
+		"dry_run": true,
+		"latency": "12ms",
+	}, data)
+
+	_, err = parseLogfmt(`msg="unterminated`)
+	assert.Error(t, err)
+}
+
+func TestDecodeMessage(t *testing.T) {
+	msg := kafka.Message{
+		Topic: "app-logs", Partition: 3, Offset: 42, Key: []byte("k1"),
+		Headers: []kafka.Header{{Key: "trace_id", Value: []byte("abc")}},
+		Value:   []byte(`[{"level":"warn"},{"level":"error"}]`),
+		Time:    time.Unix(1714521600, 0),
+	}
+	event, err := decodeMessage(FormatJSON, msg)
+	assert.NoError(t, err)
+	assert.Equal(t, "app-logs", event.Topic)
+	assert.Equal(t, 2, len(event.Datas))
+	assert.Equal(t, "app-logs[3]@42", event.Meta.Position)
+	assert.Equal(t, int64(1714521600), event.Meta.Timestamp)
+	for name, want := range map[string]string{"partition": "3", "offset": "42", "key": "k1", "header.trace_id": "abc"} {
+		value, ok := event.Meta.Get(name)
+		assert.True(t, ok)
+		assert.Equal(t, want, value)
+	}
+
+	msg.Value = []byte("plain line\n")
+	event, err = decodeMessage(FormatText, msg)
+	assert.NoError(t, err)
+	assert.Equal(t, "plain line", event.Datas[0]["message"])
+
+	msg.Value = []byte(`{"Topic":"db","Datas":[{"id":1}],"Meta":{"Table":"orders"}}`)
+	event, err = decodeMessage(FormatEvent, msg)
+	assert.NoError(t, err)
+	assert.Equal(t, "db", event.Topic)
+	assert.Equal(t, "orders", event.Meta.Table)
+	assert.Equal(t, "app-logs", event.Meta.Extra["topic"])
+
+	msg.Value = []byte("not json")
+	_, err = decodeMessage(FormatJSON, msg)
+	assert.Error(t, err)
+}
+
+type memoryQueue struct {
+	letters []stream.DeadLetter
+}
+
+func (q *memoryQueue) Write(ctx context.Context, letters ...stream.DeadLetter) error {
+	q.letters = append(q.letters, letters...)
+	return nil
+}
+
+func TestReplayRawMessage(t *testing.T) {
+	msg := kafka.Message{
+		Topic: "app-logs", Partition: 3, Offset: 42, Key: []byte("k1"),
+		Headers: []kafka.Header{{Key: "trace_id", Value: []byte("abc")}},
+		Value:   []byte(`level=warn msg="disk full"`),
+		Time:    time.Unix(1714521600, 0),
+	}
+	queue := &memoryQueue{}
+	k := &kafkaInput{BaseInput: BaseInput{deadLetter: queue}}
+	k.Format = FormatLogfmt
+	assert.NoError(t, k.rejectMessage(context.Background(), msg, errors.New("output failed")))
+	assert.Equal(t, 1, len(queue.letters))
+
+	// 重放时按消费时的格式重新解析原始消息
+	letter := queue.letters[0]
+	event, err := letter.Event(context.Background())
+	assert.NoError(t, err)
+	want, err := decodeMessage(FormatLogfmt, msg)
+	assert.NoError(t, err)
+	assert.Equal(t, want.Datas, event.Datas)
+	assert.Equal(t, want.Meta, event.Meta)
+	assert.Equal(t, "k1", event.Key)
+	assert.Equal(t, 1, event.Retries)
+
+	letter.Format = "yaml"
+	_, err = letter.Event(context.Background())
+	assert.Error(t, err)
+}
@@ -0,0 +1,389 @@
+package input
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"sort"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+)
+
+const (
+	OffsetEarliest  = "earliest"
+	OffsetLatest    = "latest"
+	OffsetTimestamp = "timestamp"
+	OffsetExplicit  = "offset"
+)
+
+// kafkaOffsetRequest 命令参数，group 为空时由所有 kafka 输入处理，topic、partitions 为空时表示全部
+type kafkaOffsetRequest struct {
+	Group      string `json:"group"`
+	Topic      string `json:"topic"`
+	Partitions []int  `json:"partitions"`
+	To         string `json:"to"`        // 重置到 earliest、latest、timestamp 或 offset
+	Timestamp  int64  `json:"timestamp"` // 毫秒，重置到这个时间之后的第一条消息
+	Offset     int64  `json:"offset"`
+}
+
+type kafkaPartitionLag struct {
+	Topic     string `json:"topic"`
+	Partition int    `json:"partition"`
+	Committed int64  `json:"committed"` // -1 表示还没有提交过
+	Earliest  int64  `json:"earliest"`
+	Latest    int64  `json:"latest"`
+	Lag       int64  `json:"lag"` // 没有提交过时为最早到最新的消息数
+}
+
+type kafkaPartitionOffset struct {
+	Topic     string `json:"topic"`
+	Partition int    `json:"partition"`
+	Offset    int64  `json:"offset"`
+}
+
+func (k *kafkaInput) registerCommand() {
+	k.commander.RegisterHandler("kafka", "lag", k.lag)
+	k.commander.RegisterHandler("kafka", "reset_offsets", k.resetOffsets)
+	k.commander.RegisterHandler("kafka", "pause", k.pause)
+	k.commander.RegisterHandler("kafka", "resume", k.resume)
+}
+
+func (k *kafkaInput) client() (*kafka.Client, error) {
+	transport, err := k.Transport()
+	if err != nil {
+		return nil, err
+	}
+	return &kafka.Client{Addr: kafka.TCP(k.Brokers...), Transport: transport, Timeout: 30 * time.Second}, nil
+}
+
+// parseOffsetRequest 返回 false 表示命令不是发给这个输入的
+func (k *kafkaInput) parseOffsetRequest(req json.RawMessage) (bool, *kafkaOffsetRequest, error) {
+	var request kafkaOffsetRequest
+	if len(req) > 0 {
+		if err := json.Unmarshal(req, &request); err != nil {
+			return true, nil, fmt.Errorf("invalid kafka command params: %w", err)
+		}
+	}
+	if request.Group != "" && request.Group != k.GroupID {
+		return false, nil, nil
+	}
+	return true, &request, nil
+}
+
+func (k *kafkaInput) lag(req json.RawMessage) (ok bool, resp interface{}, err error) {
+	ok, request, err := k.parseOffsetRequest(req)
+	if !ok || err != nil {
+		return ok, nil, err
+	}
+	if k.GroupID == "" {
+		return true, nil, errors.New("kafka input without group setting has no committed offsets")
+	}
+	ctx, cancel := context.WithTimeout(k.Context(), time.Minute)
+	defer cancel()
+	client, err := k.client()
+	if err != nil {
+		return true, nil, err
+	}
+	partitions, err := k.partitions(ctx, client, request)
+	if err != nil {
+		return true, nil, err
+	}
+	earliest, err := listOffsets(ctx, client, partitions, kafka.FirstOffsetOf)
+	if err != nil {
+		return true, nil, err
+	}
+	latest, err := listOffsets(ctx, client, partitions, kafka.LastOffsetOf)
+	if err != nil {
+		return true, nil, err
+	}
+	committed, err := k.committedOffsets(ctx, client, partitions)
+	if err != nil {
+		return true, nil, err
+	}
+	lags := []kafkaPartitionLag{}
+	for _, p := range sortedPartitions(partitions) {
+		lag := kafkaPartitionLag{Topic: p.Topic, Partition: p.Partition, Committed: -1,
+			Earliest: earliest[p.Topic][p.Partition], Latest: latest[p.Topic][p.Partition]}
+		if offset, ok := committed[p.Topic][p.Partition]; ok && offset >= 0 {
+			lag.Committed = offset
+			lag.Lag = lag.Latest - offset
+		} else {
+			lag.Lag = lag.Latest - lag.Earliest
+		}
+		lags = append(lags, lag)
+	}
+	raw, err := json.Marshal(map[string]interface{}{"group": k.GroupID, "paused": k.paused(), "partitions": lags})
+	if err != nil {
+		return true, nil, err
+	}
+	return true, string(raw), nil
+}
+
+// resetOffsets 停止消费并离开消费组后提交新的 offset，再重新加入消费组从新的 offset 消费。
+// kafka 只允许为没有成员的消费组提交 offset，同一个 group 有多个实例时需要先停止其他实例
+func (k *kafkaInput) resetOffsets(req json.RawMessage) (ok bool, resp interface{}, err error) {
+	ok, request, err := k.parseOffsetRequest(req)
+	if !ok || err != nil {
+		return ok, nil, err
+	}
+	if k.GroupID == "" {
+		return true, nil, errors.New("kafka input without group setting can not reset offsets")
+	}
+	switch request.To {
+	case OffsetEarliest, OffsetLatest, OffsetTimestamp, OffsetExplicit:
+	default:
+		return true, nil, fmt.Errorf("unknown kafka offset reset target %q", request.To)
+	}
+	ctx, cancel := context.WithTimeout(k.Context(), time.Minute)
+	defer cancel()
+	client, err := k.client()
+	if err != nil {
+		return true, nil, err
+	}
+	partitions, err := k.partitions(ctx, client, request)
+	if err != nil {
+		return true, nil, err
+	}
+	offsets, err := targetOffsets(ctx, client, partitions, request)
+	if err != nil {
+		return true, nil, err
+	}
+
+	k.resetMu.Lock()
+	defer k.resetMu.Unlock()
+	if flowCtx := k.halt(); flowCtx != nil {
+		defer func() {
+			// 无论提交是否成功都重新连接，提交失败时从原来的 offset 继续消费
+			if connErr := k.connect(); connErr != nil {
+				err = errors.Join(err, fmt.Errorf("kafka input stopped, reconnect failed: %w", connErr))
+				return
+			}
+			k.start(flowCtx)
+		}()
+	}
+	if err = commitOffsets(ctx, client, k.GroupID, offsets); err != nil {
+		return true, nil, err
+	}
+	k.logger.Info().Any("offsets", offsets).Msg("reset offsets")
+	raw, err := json.Marshal(offsets)
+	if err != nil {
+		return true, nil, err
+	}
+	return true, string(raw), nil
+}
+
+func (k *kafkaInput) pause(req json.RawMessage) (ok bool, resp interface{}, err error) {
+	ok, _, err = k.parseOffsetRequest(req)
+	if !ok || err != nil {
+		return ok, nil, err
+	}
+	k.mu.Lock()
+	if k.resumed == nil {
+		k.resumed = make(chan struct{})
+	}
+	k.mu.Unlock()
+	k.logger.Info().Msg("paused")
+	return true, fmt.Sprintf("kafka 消费组 %s 已暂停消费", k.GroupID), nil
+}
+
+func (k *kafkaInput) resume(req json.RawMessage) (ok bool, resp interface{}, err error) {
+	ok, _, err = k.parseOffsetRequest(req)
+	if !ok || err != nil {
+		return ok, nil, err
+	}
+	k.mu.Lock()
+	if k.resumed != nil {
+		close(k.resumed)
+		k.resumed = nil
+	}
+	k.mu.Unlock()
+	k.logger.Info().Msg("resumed")
+	return true, fmt.Sprintf("kafka 消费组 %s 已恢复消费", k.GroupID), nil
+}
+
+func (k *kafkaInput) paused() bool {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	return k.resumed != nil
+}
+
+// waitResume 暂停期间阻塞拉取消息，reader 仍然保持心跳，分区不会被重新分配
+func (k *kafkaInput) waitResume(ctx context.Context) error {
+	k.mu.Lock()
+	resumed := k.resumed
+	k.mu.Unlock()
+	if resumed == nil {
+		return nil
+	}
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-resumed:
+		return nil
+	}
+}
+
+// partitions 返回命令涉及的分区，topic 必须是这个输入消费的
+func (k *kafkaInput) partitions(ctx context.Context, client *kafka.Client, request *kafkaOffsetRequest) (map[string][]int, error) {
+	topics, err := k.resolveTopics()
+	if err != nil {
+		return nil, err
+	}
+	if request.Topic != "" {
+		if !slices.Contains(topics, request.Topic) {
+			return nil, fmt.Errorf("kafka input does not consume topic %s", request.Topic)
+		}
+		topics = []string{request.Topic}
+	}
+	resp, err := client.Metadata(ctx, &kafka.MetadataRequest{Topics: topics})
+	if err != nil {
+		return nil, fmt.Errorf("get kafka metadata failed: %w", err)
+	}
+	partitions := map[string][]int{}
+	for _, topic := range resp.Topics {
+		if topic.Error != nil {
+			return nil, fmt.Errorf("get kafka topic %s metadata failed: %w", topic.Name, topic.Error)
+		}
+		for _, p := range topic.Partitions {
+			if len(request.Partitions) == 0 || slices.Contains(request.Partitions, p.ID) {
+				partitions[topic.Name] = append(partitions[topic.Name], p.ID)
+			}
+		}
+	}
+	if len(partitions) == 0 {
+		return nil, errors.New("no kafka partition matches")
+	}
+	return partitions, nil
+}
+
+func (k *kafkaInput) committedOffsets(ctx context.Context, client *kafka.Client, partitions map[string][]int) (map[string]map[int]int64, error) {
+	resp, err := client.OffsetFetch(ctx, &kafka.OffsetFetchRequest{GroupID: k.GroupID, Topics: partitions})
+	if err != nil {
+		return nil, fmt.Errorf("fetch kafka committed offsets failed: %w", err)
+	}
+	if resp.Error != nil {
+		return nil, fmt.Errorf("fetch kafka committed offsets failed: %w", resp.Error)
+	}
+	offsets := map[string]map[int]int64{}
+	for topic, ps := range resp.Topics {
+		offsets[topic] = map[int]int64{}
+		for _, p := range ps {
+			if p.Error != nil {
+				return nil, fmt.Errorf("fetch kafka committed offset of %s[%d] failed: %w", topic, p.Partition, p.Error)
+			}
+			offsets[topic][p.Partition] = p.CommittedOffset
+		}
+	}
+	return offsets, nil
+}
+
+// listOffsets 按 at 查询每个分区的 offset，按时间查询时没有更新的消息返回 -1
+func listOffsets(ctx context.Context, client *kafka.Client, partitions map[string][]int, at func(partition int) kafka.OffsetRequest) (map[string]map[int]int64, error) {
+	request := &kafka.ListOffsetsRequest{Topics: map[string][]kafka.OffsetRequest{}}
+	for topic, ps := range partitions {
+		for _, p := range ps {
+			request.Topics[topic] = append(request.Topics[topic], at(p))
+		}
+	}
+	resp, err := client.ListOffsets(ctx, request)
+	if err != nil {
+		return nil, fmt.Errorf("list kafka offsets failed: %w", err)
+	}
+	offsets := map[string]map[int]int64{}
+	for topic, ps := range resp.Topics {
+		offsets[topic] = map[int]int64{}
+		for _, p := range ps {
+			if p.Error != nil {
+				return nil, fmt.Errorf("list kafka offset of %s[%d] failed: %w", topic, p.Partition, p.Error)
+			}
+			offset := max(p.FirstOffset, p.LastOffset)
+			for o := range p.Offsets {
+				offset = o
+			}
+			offsets[topic][p.Partition] = offset
+		}
+	}
+	return offsets, nil
+}
+
+// targetOffsets 计算重置后的 offset，指定的时间之后没有消息时重置到最新
+func targetOffsets(ctx context.Context, client *kafka.Client, partitions map[string][]int, request *kafkaOffsetRequest) ([]kafkaPartitionOffset, error) {
+	earliest, err := listOffsets(ctx, client, partitions, kafka.FirstOffsetOf)
+	if err != nil {
+		return nil, err
+	}
+	latest, err := listOffsets(ctx, client, partitions, kafka.LastOffsetOf)
+	if err != nil {
+		return nil, err
+	}
+	var at map[string]map[int]int64
+	if request.To == OffsetTimestamp {
+		t := time.UnixMilli(request.Timestamp)
+		at, err = listOffsets(ctx, client, partitions, func(partition int) kafka.OffsetRequest {
+			return kafka.TimeOffsetOf(partition, t)
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	offsets := sortedPartitions(partitions)
+	for i, p := range offsets {
+		first, last := earliest[p.Topic][p.Partition], latest[p.Topic][p.Partition]
+		switch request.To {
+		case OffsetEarliest:
+			p.Offset = first
+		case OffsetLatest:
+			p.Offset = last
+		case OffsetTimestamp:
+			p.Offset = last
+			if offset := at[p.Topic][p.Partition]; offset >= 0 {
+				p.Offset = offset
+			}
+		case OffsetExplicit:
+			if request.Offset < first || request.Offset > last {
+				return nil, fmt.Errorf("offset %d out of range [%d, %d] of %s[%d]", request.Offset, first, last, p.Topic, p.Partition)
+			}
+			p.Offset = request.Offset
+		}
+		offsets[i] = p
+	}
+	return offsets, nil
+}
+
+func commitOffsets(ctx context.Context, client *kafka.Client, group string, offsets []kafkaPartitionOffset) error {
+	request := &kafka.OffsetCommitRequest{GroupID: group, GenerationID: -1, Topics: map[string][]kafka.OffsetCommit{}}
+	for _, p := range offsets {
+		request.Topics[p.Topic] = append(request.Topics[p.Topic], kafka.OffsetCommit{Partition: p.Partition, Offset: p.Offset})
+	}
+	resp, err := client.OffsetCommit(ctx, request)
+	if err != nil {
+		return fmt.Errorf("commit kafka offsets failed: %w", err)
+	}
+	for topic, ps := range resp.Topics {
+		for _, p := range ps {
+			if p.Error != nil {
+				return fmt.Errorf("commit kafka offset of %s[%d] failed, the group may still have other members: %w", topic, p.Partition, p.Error)
+			}
+		}
+	}
+	return nil
+}
+
+func sortedPartitions(partitions map[string][]int) []kafkaPartitionOffset {
+	var list []kafkaPartitionOffset
+	for topic, ps := range partitions {
+		for _, p := range ps {
+			list = append(list, kafkaPartitionOffset{Topic: topic, Partition: p})
+		}
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].Topic != list[j].Topic {
+			return list[i].Topic < list[j].Topic
+		}
+		return list[i].Partition < list[j].Partition
+	})
+	return list
+}
@@ -0,0 +1,38 @@
+package input
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/longbridgeapp/assert"
+)
+
+func TestKafkaOffsetCommand(t *testing.T) {
+	k := &kafkaInput{KafkaInputConfig: KafkaInputConfig{GroupID: "sync"}}
+	ok, _, _ := k.pause([]byte(`{"group":"other"}`))
+	assert.False(t, ok)
+	assert.False(t, k.paused())
+
+	ok, _, err := k.pause([]byte(`{"group":"sync"}`))
+	assert.True(t, ok)
+	assert.NoError(t, err)
+	assert.True(t, k.paused())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	assert.Error(t, k.waitResume(ctx))
+
+	resumed := make(chan error)
+	go func() {
+		resumed <- k.waitResume(context.Background())
+	}()
+	ok, _, err = k.resume(nil)
+	assert.True(t, ok)
+	assert.NoError(t, err)
+	assert.NoError(t, <-resumed)
+	assert.False(t, k.paused())
+
+	_, _, err = k.resetOffsets([]byte(`{"to":"yesterday"}`))
+	assert.Error(t, err)
+}
@@ -0,0 +1,301 @@
+package input
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"go-data-flow/pkg/stream"
+
+	"github.com/rs/zerolog"
+	"github.com/segmentio/kafka-go"
+)
+
+const partitionRestartBackoff = time.Second
+
+// kafkaPartition 每个分区由独立的 reader 读取，把同一分区中键相同的连续消息合并为批量事件按顺序发送，
+// offset 由分区自己的 Checkpointer 在事件确认后按顺序记录，慢分区不会阻塞其他分区的读取和提交
+type kafkaPartition struct {
+	input     *kafkaInput
+	topic     string
+	id        int
+	name      string
+	committer *kafkaCommitter
+	committed atomic.Int64 // 最后一条确认完成的消息的下一个 offset，分区出错后从这里重新消费
+
+	checkpoint *stream.Checkpointer
+	batch      kafkaBatch
+	commit     *kafka.Message // 已处理但尚未提交的最后一条消息
+}
+
+// consumePartition 输出写入失败、读取失败或者消息无法转入死信队列时，与 canal 断开重连一样丢弃未确认的事件，
+// 重新打开分区从最后一次确认完成的 offset 消费
+func (k *kafkaInput) consumePartition(ctx context.Context, topic string, id int, offset int64, committer *kafkaCommitter) {
+	p := &kafkaPartition{input: k, topic: topic, id: id, name: fmt.Sprintf("%s[%d]", topic, id), committer: committer}
+	p.committed.Store(offset)
+	for !k.stop && ctx.Err() == nil {
+		err := p.run(ctx, p.committed.Load())
+		if err == nil {
+			return
+		}
+		k.stream.Err <- fmt.Errorf("kafka partition %s failed, consume from offset %d again: %w", p.name, p.committed.Load(), err)
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(partitionRestartBackoff):
+		}
+	}
+}
+
+// run 从 offset 开始消费，分区出错时返回错误，正常退出时返回 nil
+func (p *kafkaPartition) run(parent context.Context, offset int64) error {
+	k := p.input
+	ctx, cancel := context.WithCancelCause(parent)
+	defer cancel(nil)
+	p.batch, p.commit = kafkaBatch{}, nil
+	p.checkpoint = stream.NewCheckpointer(ctx, func(err error) {
+		// 之后的 offset 不会再提交，停止分区后重新打开
+		cancel(err)
+	})
+	defer p.checkpoint.Stop()
+	reader := kafka.NewReader(kafka.ReaderConfig{Brokers: k.Brokers, Topic: p.topic, Partition: p.id, Dialer: k.dialer})
+	defer reader.Close()
+	if err := reader.SetOffset(offset); err != nil {
+		return fmt.Errorf("seek to offset %d failed: %w", offset, err)
+	}
+	messages := make(chan kafka.Message, k.BatchSize)
+	go p.fetch(ctx, cancel, reader, messages)
+
+	wait := time.Duration(k.BatchWaitMs) * time.Millisecond
+	var timer *time.Timer
+	var expired <-chan time.Time
+	stopTimer := func() {
+		if timer != nil {
+			timer.Stop()
+			timer, expired = nil, nil
+		}
+	}
+	defer stopTimer()
+loop:
+	for {
+		select {
+		case <-ctx.Done():
+			break loop
+		case msg, ok := <-messages:
+			if !ok {
+				p.flush(ctx)
+				break loop
+			}
+			if err := p.add(ctx, msg); err != nil {
+				cancel(err)
+				break loop
+			}
+			if p.batch.size >= k.BatchSize || p.batch.size == 0 {
+				stopTimer()
+				p.flush(ctx)
+			} else if timer == nil {
+				timer = time.NewTimer(wait)
+				expired = timer.C
+			}
+		case <-expired:
+			timer, expired = nil, nil
+			p.flush(ctx)
+		}
+	}
+	var err error
+	if parent.Err() == nil {
+		err = context.Cause(ctx)
+	}
+	cancel(nil)
+	for range messages {
+	}
+	return err
+}
+
+// fetch 读取出错时停止分区
+func (p *kafkaPartition) fetch(ctx context.Context, cancel context.CancelCauseFunc, reader *kafka.Reader, messages chan<- kafka.Message) {
+	defer close(messages)
+	for !p.input.stop {
+		if err := p.input.waitResume(ctx); err != nil {
+			return
+		}
+		msg, err := reader.FetchMessage(ctx)
+		if err != nil {
+			if ctx.Err() == nil {
+				cancel(fmt.Errorf("fetch message failed: %w", err))
+			}
+			return
+		}
+		p.input.logger.Debug().Str("partition", p.name).Any("offset", msg.Offset).Msg("read")
+		// 从 FirstOffset、LastOffset 开始消费时，以第一条消息作为出错后重新消费的位置
+		if p.committed.Load() < 0 {
+			p.committed.Store(msg.Offset)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case messages <- msg:
+		}
+	}
+}
+
+// add 消息无法转入死信队列时返回错误，不能跳过这条消息提交之后的 offset
+func (p *kafkaPartition) add(ctx context.Context, msg kafka.Message) error {
+	k := p.input
+	event, err := k.decode(ctx, msg)
+	if err != nil {
+		if err = k.rejectMessage(ctx, msg, err); err != nil {
+			return err
+		}
+		// 转入死信队列的消息随后续的提交一起按顺序提交
+		p.commit = &msg
+		return nil
+	}
+	if !p.batch.add(event) {
+		p.flush(ctx)
+		p.batch.add(event)
+	}
+	p.commit = &msg
+	return nil
+}
+
+// flush 发送当前批量事件，并在其确认后记录最后一条消息的 offset
+func (p *kafkaPartition) flush(ctx context.Context) {
+	if p.batch.size > 0 {
+		event := p.batch.take()
+		event.Ack = stream.NewAck()
+		p.checkpoint.Track(event.Ack)
+		if !p.input.emit(ctx, event) {
+			return
+		}
+	}
+	if p.commit != nil {
+		next := p.commit.Offset + 1
+		p.commit = nil
+		p.checkpoint.Commit(func() error {
+			p.committed.Store(next)
+			return p.committer.mark(p.topic, p.id, next)
+		})
+	}
+}
+
+// kafkaBatch 同一分区内连续的、来源和分区键都相同的事件合并为一个事件，
+// 键不同的事件分到不同的批次，保证同一个键的事件由同一个 worker 按顺序处理。
+// Meta 取最后一条消息的，数据按消息顺序拼接
+type kafkaBatch struct {
+	event stream.Event
+	size  int
+}
+
+// add 事件与当前批次来源或键不同时返回 false，需要先发送当前批次
+func (b *kafkaBatch) add(event stream.Event) bool {
+	if b.size == 0 {
+		b.event, b.size = event, 1
+		return true
+	}
+	if b.event.Key != event.Key || !sameSource(b.event, event) {
+		return false
+	}
+	datas := append(b.event.Datas, event.Datas...)
+	b.event = event
+	b.event.Datas = datas
+	b.size++
+	return true
+}
+
+func (b *kafkaBatch) take() stream.Event {
+	event := b.event
+	b.event, b.size = stream.Event{}, 0
+	return event
+}
+
+func sameSource(a, b stream.Event) bool {
+	return a.Topic == b.Topic && a.Meta.Source == b.Meta.Source && a.Meta.Schema == b.Meta.Schema &&
+		a.Meta.Table == b.Meta.Table && a.Meta.Action == b.Meta.Action
+}
+
+// emit 多个分区协程共享输入流，发送事件和读取结果需要成对进行。返回 false 表示流程已停止
+func (k *kafkaInput) emit(ctx context.Context, event stream.Event) bool {
+	k.emitMu.Lock()
+	defer k.emitMu.Unlock()
+	select {
+	case <-ctx.Done():
+		return false
+	case k.stream.In <- event:
+	}
+	result := <-k.stream.Out
+	if result.Error != nil {
+		k.logger.Info().Str("topic", event.Topic).Str("key", event.Key).Int("datas", len(event.Datas)).Err(result.Error).Msg("process error")
+		k.stream.Err <- result.Error
+	}
+	return true
+}
+
+// kafkaCommitter 记录一个 generation 中各分区确认完成的 offset，按 commit_interval_ms 定期异步提交，
+// 小于 0 时每次同步提交。没有 group 时为 nil，不提交
+type kafkaCommitter struct {
+	commit   func(offsets map[string]map[int]int64) error
+	interval time.Duration
+	logger   zerolog.Logger
+
+	mu      sync.Mutex
+	offsets map[string]map[int]int64
+}
+
+func (k *kafkaInput) newCommitter(commit func(offsets map[string]map[int]int64) error) *kafkaCommitter {
+	return &kafkaCommitter{
+		commit:   commit,
+		interval: time.Duration(k.CommitIntervalMs) * time.Millisecond,
+		logger:   k.logger,
+		offsets:  map[string]map[int]int64{},
+	}
+}
+
+func (c *kafkaCommitter) mark(topic string, partition int, offset int64) error {
+	if c == nil {
+		return nil
+	}
+	if c.interval <= 0 {
+		return c.commit(map[string]map[int]int64{topic: {partition: offset}})
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.offsets[topic] == nil {
+		c.offsets[topic] = map[int]int64{}
+	}
+	c.offsets[topic][partition] = offset
+	return nil
+}
+
+func (c *kafkaCommitter) flush() error {
+	if c == nil {
+		return nil
+	}
+	c.mu.Lock()
+	offsets := c.offsets
+	c.offsets = map[string]map[int]int64{}
+	c.mu.Unlock()
+	return c.commit(offsets)
+}
+
+// run 定期提交直到 ctx 结束
+func (c *kafkaCommitter) run(ctx context.Context) {
+	if c == nil || c.interval <= 0 {
+		<-ctx.Done()
+		return
+	}
+	ticker := time.NewTicker(c.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := c.flush(); err != nil {
+				c.logger.Warn().Err(err).Msg("commit offsets failed")
+			}
+		}
+	}
+}
@@ -0,0 +1,46 @@
+package input
+
+import (
+	"testing"
+
+	"go-data-flow/pkg/stream"
+
+	"github.com/longbridgeapp/assert"
+	"github.com/segmentio/kafka-go"
+)
+
+func TestKafkaBatch(t *testing.T) {
+	var batch kafkaBatch
+	first := stream.Event{Topic: "orders", Key: "a", Meta: stream.Meta{Position: "orders[0]@1"}, Datas: []map[string]interface{}{{"id": 1}}}
+	second := stream.Event{Topic: "orders", Key: "a", Meta: stream.Meta{Position: "orders[0]@2"}, Datas: []map[string]interface{}{{"id": 2}}}
+	third := stream.Event{Topic: "orders", Key: "b", Meta: stream.Meta{Position: "orders[0]@3"}, Datas: []map[string]interface{}{{"id": 3}}}
+
+	assert.True(t, batch.add(first))
+	assert.True(t, batch.add(second))
+	assert.Equal(t, 2, batch.size)
+	// 键不同的事件分到新的批次
+	assert.False(t, batch.add(third))
+
+	event := batch.take()
+	assert.Equal(t, "a", event.Key)
+	assert.Equal(t, "orders[0]@2", event.Meta.Position)
+	assert.Equal(t, []map[string]interface{}{{"id": 1}, {"id": 2}}, event.Datas)
+	assert.Equal(t, 0, batch.size)
+
+	assert.True(t, batch.add(third))
+	other := stream.Event{Topic: "users", Key: "b", Datas: []map[string]interface{}{{"id": 4}}}
+	assert.False(t, batch.add(other))
+}
+
+func TestKafkaPartitionKey(t *testing.T) {
+	k := &kafkaInput{}
+	msg := kafka.Message{Topic: "orders", Partition: 3}
+	assert.Equal(t, "orders[3]", k.partitionKey(msg, &stream.Event{}))
+	msg.Key = []byte("k1")
+	assert.Equal(t, "k1", k.partitionKey(msg, &stream.Event{}))
+	assert.Equal(t, "e1", k.partitionKey(msg, &stream.Event{Key: "e1"}))
+
+	k.KeyPath = "user.id"
+	event := &stream.Event{Datas: []map[string]interface{}{{"user": map[string]interface{}{"id": 7}}}}
+	assert.Equal(t, "7", k.partitionKey(msg, event))
+}
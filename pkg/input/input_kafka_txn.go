@@ -0,0 +1,153 @@
+package input
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+
+	"go-data-flow/pkg/kafkaclient"
+	"go-data-flow/pkg/stream"
+
+	"github.com/segmentio/kafka-go"
+	"github.com/twmb/franz-go/pkg/kgo"
+)
+
+// newSession 事务模式使用 franz-go 的 GroupTransactSession，只读取已提交的消息，
+// 发生 rebalance 时放弃当前事务，从已提交的 offset 重新消费
+func (k *kafkaInput) newSession() (*kgo.GroupTransactSession, error) {
+	if k.GroupID == "" {
+		return nil, errors.New("transactional kafka input must have group setting")
+	}
+	if k.TxnMaxRecords <= 0 {
+		k.TxnMaxRecords = 500
+	}
+	opts, err := k.KgoOptions()
+	if err != nil {
+		return nil, err
+	}
+	reset := kgo.NewOffset().AtStart()
+	if k.Latest {
+		reset = kgo.NewOffset().AtEnd()
+	}
+	opts = append(opts,
+		kgo.TransactionalID(k.TransactionalID),
+		kgo.ConsumerGroup(k.GroupID),
+		kgo.ConsumeTopics(k.topics()...),
+		kgo.ConsumeResetOffset(reset),
+		kgo.FetchIsolationLevel(kgo.ReadCommitted()),
+		kgo.RequireStableFetchOffsets(),
+		kgo.DisableAutoCommit(),
+	)
+	if k.TopicRegex != "" {
+		opts = append(opts, kgo.ConsumeRegex())
+	}
+	return kgo.NewGroupTransactSession(opts...)
+}
+
+// topics 配置了正则时，franz-go 将所有 topic 作为正则匹配，已配置的 topic 需要转义
+func (k *kafkaInput) topics() []string {
+	if k.TopicRegex == "" {
+		return k.Topics
+	}
+	topics := []string{k.TopicRegex}
+	for _, topic := range k.Topics {
+		topics = append(topics, "^"+regexp.QuoteMeta(topic)+"$")
+	}
+	return topics
+}
+
+// transact 每次拉取的消息在一个事务中处理，所有事件被输出确认后提交事务，
+// 任一事件写入失败则放弃事务，这批消息会被重新消费
+func (k *kafkaInput) transact(ctx context.Context) {
+	defer func() {
+		k.session.Close()
+		k.logger.Info().Msg("stoped!")
+	}()
+	txn := kafkaclient.SessionTransaction(k.session)
+	for !k.stop {
+		if err := k.waitResume(ctx); err != nil {
+			return
+		}
+		fetches := k.session.PollRecords(ctx, k.TxnMaxRecords)
+		if fetches.IsClientClosed() || ctx.Err() != nil {
+			return
+		}
+		fetches.EachError(func(topic string, partition int32, err error) {
+			k.stream.Err <- fmt.Errorf("fetch %s[%d] failed: %w", topic, partition, err)
+		})
+		records := fetches.Records()
+		if len(records) == 0 {
+			continue
+		}
+		if err := k.session.Begin(); err != nil {
+			k.stream.Err <- fmt.Errorf("begin kafka transaction failed: %w", err)
+			return
+		}
+		err := k.processRecords(kafkaclient.WithTransaction(ctx, txn), records)
+		if err == nil {
+			// 选主失效时不能再提交
+			err = stream.CheckFence(ctx)
+		}
+		committed, endErr := k.session.End(ctx, kgo.TransactionEndTry(err == nil))
+		if endErr != nil {
+			k.stream.Err <- fmt.Errorf("end kafka transaction failed: %w", endErr)
+			return
+		}
+		if !committed {
+			k.logger.Warn().Err(err).Int("records", len(records)).Msg("transaction aborted, records will be consumed again")
+			if err != nil {
+				k.stream.Err <- err
+			}
+		}
+	}
+}
+
+func recordMessage(record *kgo.Record) kafka.Message {
+	msg := kafka.Message{
+		Topic:     record.Topic,
+		Partition: int(record.Partition),
+		Offset:    record.Offset,
+		Key:       record.Key,
+		Value:     record.Value,
+		Time:      record.Timestamp,
+	}
+	for _, header := range record.Headers {
+		msg.Headers = append(msg.Headers, kafka.Header{Key: header.Key, Value: header.Value})
+	}
+	return msg
+}
+
+// processRecords 出错后不再发送之后的消息，但已发送的事件可能还在输出中写入当前事务，
+// 必须等它们全部确认后才能结束事务，否则会与结束事务同时写入，或者写入下一个事务
+func (k *kafkaInput) processRecords(ctx context.Context, records []*kgo.Record) error {
+	acks := make([]*stream.Ack, 0, len(records))
+	err := k.dispatchRecords(ctx, records, &acks)
+	// 等待缓冲型输出真正写入
+	for _, ack := range acks {
+		if waitErr := ack.Wait(ctx); waitErr != nil && err == nil {
+			err = waitErr
+		}
+	}
+	return err
+}
+
+func (k *kafkaInput) dispatchRecords(ctx context.Context, records []*kgo.Record, acks *[]*stream.Ack) error {
+	for _, record := range records {
+		msg := recordMessage(record)
+		event, err := k.decode(ctx, msg)
+		if err != nil {
+			if err = k.rejectMessage(ctx, msg, err); err != nil {
+				return err
+			}
+			continue
+		}
+		event.Ack = stream.NewAck()
+		*acks = append(*acks, event.Ack)
+		k.stream.In <- event
+		if result := <-k.stream.Out; result.Error != nil {
+			return result.Error
+		}
+	}
+	return nil
+}
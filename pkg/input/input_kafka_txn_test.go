@@ -0,0 +1,55 @@
+package input
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"go-data-flow/pkg/stream"
+
+	"github.com/longbridgeapp/assert"
+	"github.com/twmb/franz-go/pkg/kgo"
+)
+
+func TestProcessRecordsWaitsDispatched(t *testing.T) {
+	k := &kafkaInput{stream: stream.NewSteam()}
+	k.Format = FormatJSON
+	records := []*kgo.Record{
+		{Topic: "orders", Value: []byte(`{"id":1}`)},
+		{Topic: "orders", Value: []byte(`{"id":2}`)},
+		{Topic: "orders", Value: []byte(`not json`)},
+		{Topic: "orders", Value: []byte(`{"id":4}`)},
+	}
+	received := make(chan stream.Event, len(records))
+	go func() {
+		for event := range k.stream.In {
+			received <- event
+			k.stream.Out <- stream.EventResult{}
+		}
+	}()
+	defer close(k.stream.In)
+
+	errc := make(chan error, 1)
+	go func() {
+		errc <- k.processRecords(context.Background(), records)
+	}()
+	first, second := <-received, <-received
+	first.Ack.Done(errors.New("write failed"))
+
+	// 第一个事件已失败，第二个事件还在写入事务，不能提前返回
+	select {
+	case <-errc:
+		t.Fatal("returned before dispatched events settled")
+	case <-time.After(50 * time.Millisecond):
+	}
+	second.Ack.Done(nil)
+	select {
+	case err := <-errc:
+		assert.Error(t, err)
+	case <-time.After(time.Second):
+		t.Fatal("not returned after dispatched events settled")
+	}
+	// 解析失败后不再发送之后的消息
+	assert.Equal(t, 0, len(received))
+}
@@ -0,0 +1,127 @@
+package kafkaclient
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+	"github.com/segmentio/kafka-go/sasl"
+	"github.com/segmentio/kafka-go/sasl/plain"
+	"github.com/segmentio/kafka-go/sasl/scram"
+)
+
+// Config kafka 连接和认证配置，输入和输出共用，在各自的配置中内联
+type Config struct {
+	Brokers        []string   `yaml:"brokers"`
+	User           string     `yaml:"user"`
+	PassWord       string     `yaml:"password"`
+	SASL           string     `yaml:"sasl"` // plain、sha256（SCRAM-SHA-256）、sha512（SCRAM-SHA-512），配置了 user 时默认 plain
+	TLS            *TLSConfig `yaml:"tls"`
+	DialTimeoutSec int        `yaml:"dial_timeout_sec"` // 默认 10 秒
+}
+
+// TLSConfig 配置后使用 TLS 连接，不指定 CA 时使用系统证书
+type TLSConfig struct {
+	CAFile             string `yaml:"ca_file"`
+	CertFile           string `yaml:"cert_file"` // 客户端证书，broker 要求双向认证时配置
+	KeyFile            string `yaml:"key_file"`
+	ServerName         string `yaml:"server_name"`
+	InsecureSkipVerify bool   `yaml:"insecure_skip_verify"`
+}
+
+func (c *Config) Validate() error {
+	if len(c.Brokers) == 0 {
+		return errors.New("kafka must have brokers setting")
+	}
+	return nil
+}
+
+// Mechanism 没有配置 user 时返回 nil
+func (c *Config) Mechanism() (sasl.Mechanism, error) {
+	if c.User == "" {
+		return nil, nil
+	}
+	switch c.SASL {
+	case "", "plain":
+		return plain.Mechanism{Username: c.User, Password: c.PassWord}, nil
+	case "sha256", "scram-sha-256":
+		return scram.Mechanism(scram.SHA256, c.User, c.PassWord)
+	case "sha512", "scram-sha-512":
+		return scram.Mechanism(scram.SHA512, c.User, c.PassWord)
+	default:
+		return nil, fmt.Errorf("unsupported kafka sasl mechanism %s", c.SASL)
+	}
+}
+
+// TLSConfig 没有配置 tls 时返回 nil
+func (c *Config) TLSConfig() (*tls.Config, error) {
+	if c.TLS == nil {
+		return nil, nil
+	}
+	cfg := &tls.Config{ServerName: c.TLS.ServerName, InsecureSkipVerify: c.TLS.InsecureSkipVerify}
+	if c.TLS.CAFile != "" {
+		raw, err := os.ReadFile(c.TLS.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("read kafka ca file failed: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(raw) {
+			return nil, fmt.Errorf("kafka ca file %s has no valid certificate", c.TLS.CAFile)
+		}
+		cfg.RootCAs = pool
+	}
+	if c.TLS.CertFile != "" || c.TLS.KeyFile != "" {
+		cert, err := tls.LoadX509KeyPair(c.TLS.CertFile, c.TLS.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("load kafka client certificate failed: %w", err)
+		}
+		cfg.Certificates = []tls.Certificate{cert}
+	}
+	return cfg, nil
+}
+
+func (c *Config) dialTimeout() time.Duration {
+	if c.DialTimeoutSec <= 0 {
+		return 10 * time.Second
+	}
+	return time.Duration(c.DialTimeoutSec) * time.Second
+}
+
+// Dialer 用于 Reader 和直接连接 broker
+func (c *Config) Dialer() (*kafka.Dialer, error) {
+	mechanism, err := c.Mechanism()
+	if err != nil {
+		return nil, err
+	}
+	tlsCfg, err := c.TLSConfig()
+	if err != nil {
+		return nil, err
+	}
+	return &kafka.Dialer{
+		Timeout:       c.dialTimeout(),
+		DualStack:     true,
+		SASLMechanism: mechanism,
+		TLS:           tlsCfg,
+	}, nil
+}
+
+// Transport 用于 Writer 和 Client
+func (c *Config) Transport() (*kafka.Transport, error) {
+	mechanism, err := c.Mechanism()
+	if err != nil {
+		return nil, err
+	}
+	tlsCfg, err := c.TLSConfig()
+	if err != nil {
+		return nil, err
+	}
+	return &kafka.Transport{
+		DialTimeout: c.dialTimeout(),
+		SASL:        mechanism,
+		TLS:         tlsCfg,
+	}, nil
+}
@@ -0,0 +1,75 @@
+package kafkaclient
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/twmb/franz-go/pkg/kgo"
+	"github.com/twmb/franz-go/pkg/sasl/plain"
+	"github.com/twmb/franz-go/pkg/sasl/scram"
+)
+
+// Transaction 由事务模式的 kafka 输入开启，通过事件的上下文传递给事务型的 kafka 输出，
+// 输出写入的消息与输入消费的 offset 在同一个事务中提交
+type Transaction interface {
+	// Produce 在事务中异步写入消息，写入确认或失败时调用 done
+	Produce(ctx context.Context, topic string, key, value []byte, done func(error))
+}
+
+type transactionKey struct{}
+
+func WithTransaction(ctx context.Context, txn Transaction) context.Context {
+	return context.WithValue(ctx, transactionKey{}, txn)
+}
+
+// TransactionFrom 上下文中没有事务时返回 nil
+func TransactionFrom(ctx context.Context) Transaction {
+	if ctx == nil {
+		return nil
+	}
+	txn, _ := ctx.Value(transactionKey{}).(Transaction)
+	return txn
+}
+
+type sessionTransaction struct {
+	session *kgo.GroupTransactSession
+}
+
+// SessionTransaction 在 GroupTransactSession 当前的事务中写入
+func SessionTransaction(session *kgo.GroupTransactSession) Transaction {
+	return &sessionTransaction{session: session}
+}
+
+func (t *sessionTransaction) Produce(ctx context.Context, topic string, key, value []byte, done func(error)) {
+	t.session.Produce(ctx, &kgo.Record{Topic: topic, Key: key, Value: value}, func(_ *kgo.Record, err error) {
+		done(err)
+	})
+}
+
+// KgoOptions franz-go 客户端的连接和认证选项
+func (c *Config) KgoOptions() ([]kgo.Opt, error) {
+	if err := c.Validate(); err != nil {
+		return nil, err
+	}
+	opts := []kgo.Opt{kgo.SeedBrokers(c.Brokers...), kgo.DialTimeout(c.dialTimeout())}
+	tlsCfg, err := c.TLSConfig()
+	if err != nil {
+		return nil, err
+	}
+	if tlsCfg != nil {
+		opts = append(opts, kgo.DialTLSConfig(tlsCfg))
+	}
+	if c.User != "" {
+		switch c.SASL {
+		case "", "plain":
+			opts = append(opts, kgo.SASL(plain.Auth{User: c.User, Pass: c.PassWord}.AsMechanism()))
+		case "sha256", "scram-sha-256":
+			opts = append(opts, kgo.SASL(scram.Auth{User: c.User, Pass: c.PassWord}.AsSha256Mechanism()))
+		case "sha512", "scram-sha-512":
+			opts = append(opts, kgo.SASL(scram.Auth{User: c.User, Pass: c.PassWord}.AsSha512Mechanism()))
+		default:
+			return nil, fmt.Errorf("unsupported kafka sasl mechanism %s", c.SASL)
+		}
+	}
+	return opts, nil
+}
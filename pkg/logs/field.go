@@ -5,4 +5,5 @@ const (
 	Canal    = "Canal"
 	PosSaver = "PosSaver"
 	Input    = "Input"
+	Election = "Election"
 )
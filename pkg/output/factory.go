@@ -2,9 +2,13 @@ package output
 
 import (
 	"go-data-flow/pkg/handler"
+	"go-data-flow/pkg/logs"
 	"go-data-flow/pkg/plugin"
+	"go-data-flow/pkg/stream"
 	"go-data-flow/pkg/util"
 	"reflect"
+
+	"github.com/rs/zerolog/log"
 )
 
 func init() {
@@ -20,7 +24,10 @@ func init() {
 }
 
 type Config struct {
+	Name    string             `yaml:"name"` // 输出名称，用于区分死信来自哪个输出，默认为输出类型
 	Plugins []*plugin.Config   `yaml:"plugins"`
+	Retry   util.RetryConfig   `yaml:"retry"`
+	Breaker util.BreakerConfig `yaml:"circuit_breaker"`
 	Elastic *ElasticConfig     `yaml:"elastic"`
 	Kafka   *KafkaOutputConfig `yaml:"kafka"`
 	Stdout  *struct{}          `yaml:"stdout"`
@@ -37,12 +44,19 @@ type OutputFactory func(base BaseOutput, cfg interface{}) (Output, error)
 
 var factories = make(map[string]OutputFactory)
 
-func Factory(cancelable *util.Cancelable, cfg Config) (handler.Handler, error) {
+func Factory(cancelable *util.Cancelable, cfg Config, deadLetter stream.DeadLetterQueue) (handler.Handler, error) {
+	link, _, err := newLink(cancelable, cfg, deadLetter)
+	return link, err
+}
+
+// newLink 创建插件和输出组成的处理链，同时返回其中的输出
+func newLink(cancelable *util.Cancelable, cfg Config, deadLetter stream.DeadLetterQueue) (*handler.LinkHandler, []Output, error) {
 	link := &handler.LinkHandler{}
+	var outputs []Output
 	for _, plugins := range cfg.Plugins {
 		pitem, err := plugin.Factory(*plugins)
 		if err != nil {
-			return nil, err
+			return nil, nil, err
 		}
 		link.Append(pitem)
 	}
@@ -53,18 +67,35 @@ func Factory(cancelable *util.Cancelable, cfg Config) (handler.Handler, error) {
 		if field.Kind() == reflect.Ptr && field.IsNil() {
 			continue
 		}
-		factory, exists := factories[fieldType.Tag.Get("yaml")]
+		name := fieldType.Tag.Get("yaml")
+		factory, exists := factories[name]
 		if exists {
 			matcher, err := handler.NewDefaultMatcher(handler.GetMatchConfig(field))
 			if err != nil {
-				return nil, err
+				return nil, nil, err
+			}
+			logger := log.With().Any(logs.Output, name).Logger()
+			breaker := util.NewBreaker(cfg.Breaker, func(from, to util.BreakerState) {
+				logger.Warn().Stringer("from", from).Stringer("to", to).Msg("circuit breaker state changed")
+			})
+			base := BaseOutput{
+				Cancelable: cancelable,
+				Matcher:    matcher,
+				DeadLetter: deadLetter,
+				stage:      "output." + name,
+				retrier:    util.NewRetrier(cfg.Retry, retryable(cfg.Retry.RetryUnknown)),
+				breaker:    breaker,
+			}
+			if cfg.Name != "" {
+				base.stage = "output." + cfg.Name
 			}
-			oitem, err := factory(BaseOutput{Cancelable: cancelable, Matcher: matcher}, field.Interface())
+			oitem, err := factory(base, field.Interface())
 			if err != nil {
-				return nil, err
+				return nil, nil, err
 			}
 			link.Append(oitem)
+			outputs = append(outputs, oitem)
 		}
 	}
-	return link, nil
+	return link, outputs, nil
 }
@@ -5,9 +5,12 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"go-data-flow/pkg/kafkaclient"
 	"go-data-flow/pkg/logs"
 	"go-data-flow/pkg/stream"
 	"go-data-flow/pkg/util"
+	"go-data-flow/pkg/util/jsonpath"
+	"reflect"
 	"strings"
 	"time"
 
@@ -17,23 +20,114 @@ import (
 )
 
 type KafkaOutputConfig struct {
-	Topic           string   `yaml:"topic"`
-	Key             string   `yaml:"key"`
-	KeyTemplate     string   `yaml:"key_tempalte"`
-	Brokers         []string `yaml:"brokers"`
-	CompressionType string   `yaml:"compression.type"`
-	MessageMaxCount int      `yaml:"message.max.count"`
-	BulkSize        int      `yaml:"bulk_size"`
-	BulkFlushSec    int      `yaml:"bulk_flush_sec"`
+	kafkaclient.Config `yaml:",inline"`
+	Topic              string `yaml:"topic"`
+	Key                string `yaml:"key"`
+	// 消息 key 模板，例如 {table}:{id}，字段依次从行、数据中查找，
+	// 另外支持 {@key}（事件的分区键，canal 为表名和主键）、{@topic} 以及 {@table} 等事件元数据
+	KeyTemplate       string `yaml:"key_template"`
+	LegacyKeyTemplate string `yaml:"key_tempalte"`      // 已废弃，使用 key_template
+	Balancer          string `yaml:"balancer"`          // 分区方式：murmur2（与 Java 客户端一致）、hash（FNV-1a，与 Sarama 一致）、crc32、round_robin、least_bytes
+	MessagePerRow     bool   `yaml:"message_per_row"`   // 每行发送一条消息，默认每个事件一条消息
+	RowsField         string `yaml:"rows_field"`        // 行字段，默认 rows，数据中没有时整条数据作为一行
+	CompressionType   string `yaml:"compression.type"`  // gzip、snappy、lz4、zstd，默认不压缩
+	MessageMaxCount   int    `yaml:"message.max.count"` // 每个请求最多的消息数，默认 100
+	BatchBytes        int64  `yaml:"batch_bytes"`       // 每个请求最大字节数，默认 1MB，不能超过 broker 的 message.max.bytes
+	BatchTimeoutMs    int    `yaml:"batch_timeout_ms"`  // 请求未满时最长等待时间，默认 10ms
+	Acks              string `yaml:"acks"`              // all（默认）、1 只等待 leader、0 不等待确认
+	// 事件来自事务模式的 kafka 输入时，在输入的事务中写入，与消费的 offset 一起提交。
+	// 要求与输入在同一个集群，事务中写入使用输入的连接配置和 franz-go 默认的分区方式（与 Java 客户端一致），不使用本输出的压缩和分区配置
+	Transactional bool `yaml:"transactional"`
+	BulkSize      int  `yaml:"bulk_size"`
+	BulkFlushSec  int  `yaml:"bulk_flush_sec"`
+}
+
+const (
+	BalancerMurmur2    = "murmur2"
+	BalancerHash       = "hash"
+	BalancerCRC32      = "crc32"
+	BalancerRoundRobin = "round_robin"
+	BalancerLeastBytes = "least_bytes"
+)
+
+// newBalancer 配置了 key 时默认 murmur2，保证相同 key 的消息进入同一分区，否则默认 least_bytes
+func newBalancer(name string, keyed bool) (kafka.Balancer, error) {
+	if name == "" {
+		name = BalancerLeastBytes
+		if keyed {
+			name = BalancerMurmur2
+		}
+	}
+	switch name {
+	case BalancerMurmur2:
+		return kafka.Murmur2Balancer{}, nil
+	case BalancerHash:
+		return &kafka.Hash{}, nil
+	case BalancerCRC32:
+		return kafka.CRC32Balancer{}, nil
+	case BalancerRoundRobin:
+		return &kafka.RoundRobin{}, nil
+	case BalancerLeastBytes:
+		return &kafka.LeastBytes{}, nil
+	default:
+		return nil, fmt.Errorf("unknown kafka balancer %s", name)
+	}
+}
+
+func parseAcks(acks string) (kafka.RequiredAcks, error) {
+	switch acks {
+	case "", "all", "-1":
+		return kafka.RequireAll, nil
+	case "1", "one":
+		return kafka.RequireOne, nil
+	case "0", "none":
+		return kafka.RequireNone, nil
+	default:
+		return 0, fmt.Errorf("unknown kafka acks %s", acks)
+	}
+}
+
+// newWriter 按配置创建 Writer
+func newWriter(cfg *KafkaOutputConfig, balancer kafka.Balancer) (*kafka.Writer, error) {
+	transport, err := cfg.Transport()
+	if err != nil {
+		return nil, err
+	}
+	acks, err := parseAcks(cfg.Acks)
+	if err != nil {
+		return nil, err
+	}
+	writer := &kafka.Writer{
+		Addr:         kafka.TCP(cfg.Brokers...),
+		Topic:        cfg.Topic,
+		Balancer:     balancer,
+		Transport:    transport,
+		RequiredAcks: acks,
+		BatchSize:    cfg.MessageMaxCount,
+		BatchBytes:   cfg.BatchBytes,
+		BatchTimeout: time.Duration(cfg.BatchTimeoutMs) * time.Millisecond,
+	}
+	if cfg.CompressionType != "" {
+		if err = writer.Compression.UnmarshalText([]byte(cfg.CompressionType)); err != nil {
+			return nil, err
+		}
+	}
+	return writer, nil
+}
+
+// messageWriter 批量发送消息，由 kafka.Writer 实现
+type messageWriter interface {
+	WriteMessages(ctx context.Context, msgs ...kafka.Message) error
 }
 
 type KafkaOutput struct {
 	BaseOutput
-	config   *KafkaOutputConfig
-	producer *kafka.Writer
-	bulk     *util.Bulk[stream.Event]
-	dataCh   chan []util.BulkItem[stream.Event]
-	logger   zerolog.Logger
+	config      *KafkaOutputConfig
+	keyTemplate *util.Template
+	producer    messageWriter
+	bulk        *util.Bulk[stream.Event]
+	dataCh      chan []util.BulkItem[stream.Event]
+	logger      zerolog.Logger
 }
 
 func NewKafkaOutput(base BaseOutput, cfg *KafkaOutputConfig) (*KafkaOutput, error) {
@@ -45,8 +139,7 @@ func NewKafkaOutput(base BaseOutput, cfg *KafkaOutputConfig) (*KafkaOutput, erro
 		err := errors.New("kafka output must have topic setting")
 		return nil, err
 	}
-	if len(cfg.Brokers) == 0 {
-		err := errors.New("kafka output must have bootstrap.servers setting")
+	if err := cfg.Validate(); err != nil {
 		return nil, err
 	}
 	if cfg.BulkSize == 0 {
@@ -55,34 +148,160 @@ func NewKafkaOutput(base BaseOutput, cfg *KafkaOutputConfig) (*KafkaOutput, erro
 	if cfg.BulkFlushSec == 0 {
 		cfg.BulkFlushSec = 5
 	}
-	wconfig := kafka.WriterConfig{
-		Brokers:  cfg.Brokers,
-		Topic:    cfg.Topic,
-		Balancer: &kafka.LeastBytes{},
+	if cfg.BatchTimeoutMs == 0 {
+		cfg.BatchTimeoutMs = 10
+	}
+	if cfg.RowsField == "" {
+		cfg.RowsField = "rows"
+	}
+	if cfg.KeyTemplate == "" {
+		cfg.KeyTemplate = cfg.LegacyKeyTemplate
+	}
+	if cfg.KeyTemplate != "" {
+		tpl, err := util.ParseTemplate(cfg.KeyTemplate)
+		if err != nil {
+			return nil, err
+		}
+		p.keyTemplate = tpl
+	}
+	balancer, err := newBalancer(cfg.Balancer, cfg.Key != "" || cfg.KeyTemplate != "")
+	if err != nil {
+		return nil, err
+	}
+	producer, err := newWriter(cfg, balancer)
+	if err != nil {
+		return nil, err
 	}
 	p.logger = log.With().Any(logs.Output, "Kafka").Logger()
+	if cfg.Transactional && (cfg.CompressionType != "" || cfg.Balancer != "" || cfg.Acks != "") {
+		p.logger.Warn().Str("compression", cfg.CompressionType).Str("balancer", cfg.Balancer).Str("acks", cfg.Acks).
+			Msg("compression, balancer and acks are not used when writing in the input transaction")
+	}
 	p.dataCh = make(chan []util.BulkItem[stream.Event])
 	p.bulk = util.NewBulk(cfg.BulkSize, time.Duration(cfg.BulkFlushSec)*time.Second, p.dataCh)
-	p.producer = kafka.NewWriter(wconfig)
+	p.producer = producer
 	p.Run()
 	return p, nil
 }
 
-func generateKey(data map[string]interface{}, template string) (string, error) {
-	key := template
-	for placeholder, value := range data {
-		placeholderTag := fmt.Sprintf("{%s}", placeholder)
-		if strings.Contains(key, placeholderTag) {
-			key = strings.ReplaceAll(key, placeholderTag, fmt.Sprint(value))
+// kafkaRow 一条消息对应的数据，按行发送时 datas 只有一条数据且只包含一行
+type kafkaRow struct {
+	event *stream.Event
+	datas []map[string]interface{}
+	row   map[string]interface{} // 用于生成 key 的行
+}
+
+// rows 按配置将事件拆分为消息
+func (k *KafkaOutput) rows(event *stream.Event) []kafkaRow {
+	if !k.config.MessagePerRow {
+		row := kafkaRow{event: event, datas: event.Datas}
+		if len(event.Datas) > 0 {
+			row.row = firstRow(event.Datas[0], k.config.RowsField)
 		}
+		return []kafkaRow{row}
 	}
+	out := []kafkaRow{}
+	for _, data := range event.Datas {
+		for _, split := range splitRows(data, k.config.RowsField) {
+			out = append(out, kafkaRow{
+				event: event,
+				datas: []map[string]interface{}{split},
+				row:   firstRow(split, k.config.RowsField),
+			})
+		}
+	}
+	return out
+}
 
-	key = strings.ReplaceAll(key, "{", "")
-	key = strings.ReplaceAll(key, "}", "")
-	return key, nil
+func firstRow(data map[string]interface{}, field string) map[string]interface{} {
+	switch rows := data[field].(type) {
+	case []map[string]interface{}:
+		if len(rows) > 0 {
+			return rows[0]
+		}
+	case []interface{}:
+		if len(rows) > 0 {
+			if row, ok := rows[0].(map[string]interface{}); ok {
+				return row
+			}
+		}
+	}
+	return data
+}
+
+// splitRows 将数据按行拆分，与行字段等长的数组字段（如 canal 的 before、changed）一起拆分
+func splitRows(data map[string]interface{}, field string) []map[string]interface{} {
+	rows := reflect.ValueOf(data[field])
+	if rows.Kind() != reflect.Slice || rows.Len() <= 1 {
+		return []map[string]interface{}{data}
+	}
+	out := make([]map[string]interface{}, rows.Len())
+	for idx := range out {
+		split := make(map[string]interface{}, len(data))
+		for key, value := range data {
+			if v := reflect.ValueOf(value); v.Kind() == reflect.Slice && v.Len() == rows.Len() {
+				value = v.Slice(idx, idx+1).Interface()
+			}
+			split[key] = value
+		}
+		out[idx] = split
+	}
+	return out
+}
+
+// key 未配置 key 时返回 nil，由分区器随机或轮询选择分区
+func (k *KafkaOutput) key(row kafkaRow) ([]byte, error) {
+	if k.keyTemplate == nil {
+		if k.config.Key == "" {
+			return nil, nil
+		}
+		return []byte(k.config.Key), nil
+	}
+	var data map[string]interface{}
+	if len(row.datas) > 0 {
+		data = row.datas[0]
+	}
+	key, err := k.keyTemplate.ExecuteFunc(func(field string) interface{} {
+		if strings.HasPrefix(field, "@") {
+			return eventField(row.event, field[1:])
+		}
+		if value := jsonpath.Get(row.row, field); value != nil {
+			return value
+		}
+		return jsonpath.Get(data, field)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return []byte(key), nil
+}
+
+func eventField(event *stream.Event, name string) interface{} {
+	switch name {
+	case "key":
+		if event.Key != "" {
+			return event.Key
+		}
+	case "topic":
+		return event.Topic
+	default:
+		if value, ok := event.Meta.Get(name); ok {
+			return value
+		}
+	}
+	return nil
 }
 
 func (k *KafkaOutput) OnEvent(ctx context.Context, params *stream.Event) error {
+	if k.config.Transactional {
+		if txn := kafkaclient.TransactionFrom(params.Context); txn != nil {
+			return k.produceTransaction(ctx, params, txn)
+		}
+	}
+	if err := k.waitAvailable(); err != nil {
+		return err
+	}
+	params.Ack.Add()
 	k.bulk.Add(util.BulkItem[stream.Event]{Data: *params, Type: params.Topic, Size: len(params.Datas)})
 	return nil
 }
@@ -100,9 +319,11 @@ func (k *KafkaOutput) Run() {
 				k.flushRemainingData(ctx)
 				return
 			case batch := <-k.dataCh:
-				if err := k.processBatch(ctx, batch); err != nil {
+				err := k.processBatch(ctx, batch)
+				if err != nil {
 					k.logger.Error().Err(err).Msg("failed to process batch")
 				}
+				ackBatch(batch, k.deadLetter(ctx, batch, err))
 			}
 		}
 	}()
@@ -116,42 +337,124 @@ func (k *KafkaOutput) processBatch(ctx context.Context, batch []util.BulkItem[st
 	if len(params) == 0 {
 		return nil
 	}
-	msgs := make([]kafka.Message, len(params))
-	for idx, param := range params {
-		data := map[string]interface{}{
-			"Topic": param.Topic,
-			"Datas": param.Datas,
+	msgs := make([]kafka.Message, 0, len(params))
+	rows := make([]kafkaRow, 0, len(params))
+	for idx := range params {
+		for _, row := range k.rows(&params[idx]) {
+			msg, err := k.message(row)
+			if err != nil {
+				// 只跳过无法生成消息的行，同批次的其他消息照常发送
+				if err = k.rejectRow(ctx, row, err); err != nil {
+					return util.Permanent(err)
+				}
+				continue
+			}
+			msgs = append(msgs, msg)
+			rows = append(rows, row)
 		}
-		buf, err := json.Marshal(data)
-		if err != nil {
-			k.logger.Error().Err(err).Any("topic", k.config.Topic).Any("value topic", param.Topic).Msgf("marshal %v ", data)
-			return err
+	}
+	if len(msgs) == 0 {
+		return nil
+	}
+	// 部分消息写入失败时只重试失败的消息
+	pending := msgs
+	pendingRows := rows
+	err := k.writeBatch(func() error {
+		err := k.producer.WriteMessages(ctx, pending...)
+		var writeErrs kafka.WriteErrors
+		if errors.As(err, &writeErrs) && len(writeErrs) == len(pending) {
+			var failed []kafka.Message
+			var failedRows []kafkaRow
+			for idx, werr := range writeErrs {
+				if werr != nil {
+					failed = append(failed, pending[idx])
+					failedRows = append(failedRows, pendingRows[idx])
+				}
+			}
+			pending, pendingRows = failed, failedRows
 		}
-		key := k.config.Key
-		if key == "" && k.config.KeyTemplate != "" {
-			// key = generateKey(params.Datas[],k.config.KeyTemplate)
+		return err
+	})
+	if err != nil {
+		k.logger.Error().Err(err).Any("topic", k.config.Topic).Any("message count", len(msgs)).Any("failed count", len(pending)).Msg("failed ")
+		failed := make([]stream.Event, len(pendingRows))
+		for idx, row := range pendingRows {
+			failed[idx] = *row.event
+			failed[idx].Datas = row.datas
 		}
-		msgs[idx] = kafka.Message{
-			Key:   []byte(k.config.Key),
-			Value: buf,
+		return &batchError{err: err, failed: failed}
+	}
+	k.logger.Info().Any("topic", k.config.Topic).Any("write message count", len(msgs)).Msg("success")
+	return nil
+}
+
+func (k *KafkaOutput) messages(ctx context.Context, event *stream.Event) ([]kafka.Message, error) {
+	msgs := []kafka.Message{}
+	for _, row := range k.rows(event) {
+		msg, err := k.message(row)
+		if err != nil {
+			if err = k.rejectRow(ctx, row, err); err != nil {
+				return nil, util.Permanent(err)
+			}
+			continue
 		}
+		msgs = append(msgs, msg)
+	}
+	return msgs, nil
+}
+
+// rejectRow 无法生成消息的行转入死信队列，未配置死信队列时记录错误后跳过
+func (k *KafkaOutput) rejectRow(ctx context.Context, row kafkaRow, err error) error {
+	k.logger.Error().Err(err).Any("topic", k.config.Topic).Any("datas", row.datas).Msg("message rejected")
+	if k.DeadLetter == nil {
+		return nil
 	}
-	err := k.producer.WriteMessages(ctx, msgs...)
+	letter := stream.NewDeadLetter(row.event, k.Stage()+".message", err)
+	letter.Datas = row.datas
+	return k.DeadLetter.Write(ctx, letter)
+}
+
+func (k *KafkaOutput) message(row kafkaRow) (kafka.Message, error) {
+	data := map[string]interface{}{
+		"Topic": row.event.Topic,
+		"Datas": row.datas,
+	}
+	buf, err := json.Marshal(data)
+	if err != nil {
+		k.logger.Error().Err(err).Any("topic", k.config.Topic).Any("value topic", row.event.Topic).Msgf("marshal %v ", data)
+		return kafka.Message{}, util.Permanent(err)
+	}
+	key, err := k.key(row)
+	if err != nil {
+		k.logger.Error().Err(err).Any("topic", k.config.Topic).Any("value topic", row.event.Topic).Msg("generate key failed")
+		return kafka.Message{}, util.Permanent(err)
+	}
+	return kafka.Message{Key: key, Value: buf}, nil
+}
+
+// produceTransaction 在输入开启的事务中写入，每条消息确认后 Done，由输入等待确认后提交事务
+func (k *KafkaOutput) produceTransaction(ctx context.Context, event *stream.Event, txn kafkaclient.Transaction) error {
+	msgs, err := k.messages(ctx, event)
 	if err != nil {
-		k.logger.Error().Err(err).Any("topic", k.config.Topic).Any("message count", len(msgs)).Msg("failed ")
-	} else {
-		k.logger.Info().Any("topic", k.config.Topic).Any("write message count", len(msgs)).Msg("success")
+		batch := []util.BulkItem[stream.Event]{{Data: *event, Type: event.Topic, Size: len(event.Datas)}}
+		return k.deadLetter(ctx, batch, err)
 	}
-	return err
+	for _, msg := range msgs {
+		event.Ack.Add()
+		txn.Produce(ctx, k.config.Topic, msg.Key, msg.Value, event.Ack.Done)
+	}
+	return nil
 }
 
 func (k *KafkaOutput) flushRemainingData(ctx context.Context) {
 	k.logger.Info().Msgf("kafka flush remaining data")
 	select {
 	case remainingBatch := <-k.dataCh:
-		if err := k.processBatch(ctx, remainingBatch); err != nil {
+		err := k.processBatch(ctx, remainingBatch)
+		if err != nil {
 			k.logger.Error().Err(err).Msg("error flushing remaining data to kafka")
 		}
+		ackBatch(remainingBatch, k.deadLetter(ctx, remainingBatch, err))
 	default:
 		// 无剩余数据
 	}
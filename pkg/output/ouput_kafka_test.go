@@ -0,0 +1,139 @@
+package output
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"go-data-flow/pkg/kafkaclient"
+	"go-data-flow/pkg/stream"
+	"go-data-flow/pkg/util"
+
+	"github.com/longbridgeapp/assert"
+	"github.com/segmentio/kafka-go"
+)
+
+func TestKafkaRows(t *testing.T) {
+	tpl, err := util.ParseTemplate("{table}:{id}:{@key}")
+	assert.NoError(t, err)
+	k := &KafkaOutput{config: &KafkaOutputConfig{RowsField: "rows", MessagePerRow: true}, keyTemplate: tpl}
+	event := &stream.Event{Key: "shop.orders:1", Datas: []map[string]interface{}{{
+		"action":  "update",
+		"table":   "shop.orders",
+		"rows":    []map[string]interface{}{{"id": 1}, {"id": 2}},
+		"before":  []map[string]interface{}{{"id": 1}, {"id": 2}},
+		"changed": [][]string{{"a"}, {"b"}},
+	}}}
+
+	rows := k.rows(event)
+	assert.Equal(t, 2, len(rows))
+	assert.Equal(t, [][]string{{"b"}}, rows[1].datas[0]["changed"])
+	key, err := k.key(rows[1])
+	assert.NoError(t, err)
+	assert.Equal(t, "shop.orders:2:shop.orders:1", string(key))
+
+	k.config.MessagePerRow = false
+	rows = k.rows(event)
+	assert.Equal(t, 1, len(rows))
+	key, err = k.key(rows[0])
+	assert.NoError(t, err)
+	assert.Equal(t, "shop.orders:1:shop.orders:1", string(key))
+
+	k.keyTemplate = nil
+	key, err = k.key(rows[0])
+	assert.NoError(t, err)
+	assert.Nil(t, key)
+}
+
+func TestKafkaWriter(t *testing.T) {
+	cfg := &KafkaOutputConfig{Topic: "orders", CompressionType: "zstd", Acks: "1", BatchBytes: 2 << 20}
+	cfg.Brokers = []string{"127.0.0.1:9092"}
+	cfg.User, cfg.PassWord, cfg.SASL = "user", "pass", "sha512"
+	writer, err := newWriter(cfg, &kafka.LeastBytes{})
+	assert.NoError(t, err)
+	assert.Equal(t, kafka.Zstd, writer.Compression)
+	assert.Equal(t, kafka.RequireOne, writer.RequiredAcks)
+	assert.Equal(t, int64(2<<20), writer.BatchBytes)
+	assert.NotNil(t, writer.Transport.(*kafka.Transport).SASL)
+
+	cfg.CompressionType = "brotli"
+	_, err = newWriter(cfg, &kafka.LeastBytes{})
+	assert.Error(t, err)
+}
+
+type fakeTransaction struct {
+	topics []string
+	done   []func(error)
+}
+
+func (f *fakeTransaction) Produce(ctx context.Context, topic string, key, value []byte, done func(error)) {
+	f.topics = append(f.topics, topic)
+	f.done = append(f.done, done)
+}
+
+func TestKafkaTransaction(t *testing.T) {
+	k := &KafkaOutput{config: &KafkaOutputConfig{Topic: "cleaned", RowsField: "rows", MessagePerRow: true, Transactional: true}}
+	txn := &fakeTransaction{}
+	event := &stream.Event{
+		Context: kafkaclient.WithTransaction(context.Background(), txn),
+		Datas:   []map[string]interface{}{{"rows": []interface{}{map[string]interface{}{"id": 1}, map[string]interface{}{"id": 2}}}},
+		Ack:     stream.NewAck(),
+	}
+	assert.NoError(t, k.OnEvent(context.Background(), event))
+	assert.Equal(t, []string{"cleaned", "cleaned"}, txn.topics)
+
+	event.Ack.Done(nil)
+	txn.done[0](nil)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	assert.Error(t, event.Ack.Wait(ctx))
+	txn.done[1](errors.New("aborted"))
+	assert.Error(t, event.Ack.Wait(context.Background()))
+}
+
+type fakeWriter struct {
+	msgs []kafka.Message
+}
+
+func (f *fakeWriter) WriteMessages(ctx context.Context, msgs ...kafka.Message) error {
+	f.msgs = append(f.msgs, msgs...)
+	return nil
+}
+
+func TestKafkaRejectRow(t *testing.T) {
+	tpl, err := util.ParseTemplate("{id}")
+	assert.NoError(t, err)
+	writer := &fakeWriter{}
+	queue := &memoryQueue{}
+	k := &KafkaOutput{
+		BaseOutput: BaseOutput{
+			Cancelable: util.NewCancelable(context.Background()),
+			retrier:    util.NewRetrier(util.RetryConfig{MaxAttempts: 1}, retryable(false)),
+			breaker:    util.NewBreaker(util.BreakerConfig{FailureThreshold: -1}, nil),
+			DeadLetter: queue,
+			stage:      "output.kafka",
+		},
+		config:      &KafkaOutputConfig{Topic: "orders", RowsField: "rows", MessagePerRow: true},
+		keyTemplate: tpl,
+		producer:    writer,
+	}
+	event := stream.Event{Topic: "orders", Datas: []map[string]interface{}{
+		{"rows": []interface{}{map[string]interface{}{"id": 1}, map[string]interface{}{"name": "no id"}, map[string]interface{}{"id": 3}}},
+	}}
+	batch := []util.BulkItem[stream.Event]{{Data: event}}
+
+	// 只有无法生成 key 的行转入死信队列，其他消息照常发送
+	assert.NoError(t, k.processBatch(context.Background(), batch))
+	assert.Equal(t, 2, len(writer.msgs))
+	assert.Equal(t, "1", string(writer.msgs[0].Key))
+	assert.Equal(t, "3", string(writer.msgs[1].Key))
+	assert.Equal(t, 1, len(queue.letters))
+	assert.Equal(t, "output.kafka.message", queue.letters[0].Stage)
+	assert.Equal(t, []interface{}{map[string]interface{}{"name": "no id"}}, queue.letters[0].Datas[0]["rows"])
+
+	// 没有死信队列时跳过该行
+	writer.msgs, k.DeadLetter = nil, nil
+	assert.NoError(t, k.processBatch(context.Background(), batch))
+	assert.Equal(t, 2, len(writer.msgs))
+}
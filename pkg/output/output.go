@@ -1,28 +1,165 @@
 package output
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"go-data-flow/pkg/handler"
+	"go-data-flow/pkg/stream"
 	"go-data-flow/pkg/util"
+	"io"
+	"net"
+	"net/http"
+	"syscall"
+
+	"github.com/olivere/elastic/v7"
+	"github.com/segmentio/kafka-go"
 )
 
 type Output interface {
 	handler.Handler
 	handler.Matcher
+	Stage() string
 }
 
-func Outputs(cancelable *util.Cancelable, cfgs []Config) (*handler.LinkHandler, error) {
+// Outputs 创建流程的所有输出，同时返回按死信阶段索引的输出，重放死信时直接写入失败的输出
+func Outputs(cancelable *util.Cancelable, cfgs []Config, deadLetter stream.DeadLetterQueue) (*handler.LinkHandler, map[string]Output, error) {
 	link := &handler.LinkHandler{Head: true}
+	stages := map[string]Output{}
 	for _, cfg := range cfgs {
-		output, err := Factory(cancelable, cfg)
+		output, outputs, err := newLink(cancelable, cfg, deadLetter)
 		if err != nil {
-			return link, err
+			return link, stages, err
+		}
+		for _, item := range outputs {
+			if _, ok := stages[item.Stage()]; ok && deadLetter != nil {
+				return link, stages, fmt.Errorf("duplicate output %s, set name to tell them apart", item.Stage())
+			}
+			stages[item.Stage()] = item
 		}
 		link.Append(output)
 	}
-	return link, nil
+	return link, stages, nil
 }
 
 type BaseOutput struct {
 	*util.Cancelable
 	handler.Matcher
+	DeadLetter stream.DeadLetterQueue // 未配置死信队列时为 nil
+	stage      string
+	retrier    *util.Retrier
+	breaker    *util.Breaker
+}
+
+// Stage 写入失败转入死信队列时记录的阶段，如 output.elastic，配置了 name 时为 output.<name>
+func (o *BaseOutput) Stage() string {
+	return o.stage
+}
+
+// writeBatch 按重试策略写入批次。可重试的错误一直重试当前批次直到成功或退出，不会因为熔断还没打开就放弃批次，
+// 每轮重试失败计入熔断，熔断后等待探测时间再重试，熔断期间 OnEvent 阻塞，流程的输入随之暂停
+func (o *BaseOutput) writeBatch(write func() error) error {
+	ctx := o.Context()
+	for {
+		err := o.retrier.Do(ctx, write)
+		if err == nil {
+			o.breaker.Success()
+			return nil
+		}
+		if !o.retrier.Retryable(err) {
+			return err
+		}
+		wait := o.retrier.Pause
+		if o.breaker.Failure() {
+			wait = o.breaker.WaitProbe
+		}
+		if wait(ctx) != nil {
+			return err
+		}
+	}
+}
+
+// waitAvailable 熔断期间堵塞接收事件
+func (o *BaseOutput) waitAvailable() error {
+	return o.breaker.WaitClosed(o.Context())
+}
+
+// errRetryableItems 批量写入中部分文档返回了 429 或 5xx
+var errRetryableItems = errors.New("retryable status")
+
+// retryable 判断写入错误是否可以重试：网络错误、超时、5xx 和 429 可以重试，其他 4xx 等请求错误重试也不会成功。
+// 无法识别的错误按 retry_unknown 配置处理，默认不重试，转入死信队列或由输入端重新同步
+func retryable(retryUnknown bool) func(error) bool {
+	var check func(err error) bool
+	check = func(err error) bool {
+		var esErr *elastic.Error
+		if errors.As(err, &esErr) {
+			return esErr.Status >= 500 || esErr.Status == http.StatusTooManyRequests || esErr.Status == http.StatusRequestTimeout
+		}
+		var writeErrs kafka.WriteErrors
+		if errors.As(err, &writeErrs) {
+			for _, werr := range writeErrs {
+				if werr != nil && !check(werr) {
+					return false
+				}
+			}
+			return true
+		}
+		var kafkaErr kafka.Error
+		if errors.As(err, &kafkaErr) {
+			return kafkaErr.Temporary()
+		}
+		var netErr net.Error
+		if errors.As(err, &netErr) || errors.Is(err, errRetryableItems) || errors.Is(err, elastic.ErrNoClient) || errors.Is(err, context.DeadlineExceeded) ||
+			errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) ||
+			errors.Is(err, syscall.ECONNREFUSED) || errors.Is(err, syscall.ECONNRESET) || errors.Is(err, syscall.EPIPE) {
+			return true
+		}
+		return retryUnknown
+	}
+	return check
+}
+
+// batchError 批次中的部分数据已经写入，只有 failed 中未写入的数据转入死信队列
+type batchError struct {
+	err    error
+	failed []stream.Event
+}
+
+func (e *batchError) Error() string {
+	return e.err.Error()
+}
+
+func (e *batchError) Unwrap() error {
+	return e.err
+}
+
+// deadLetter 写入失败的批次转入死信队列，转入成功后视为处理完成，输入端可以继续提交位置。
+// 部分写入的批次只转入未写入的数据，重放时不会重复写入
+func (o *BaseOutput) deadLetter(ctx context.Context, batch []util.BulkItem[stream.Event], err error) error {
+	if err == nil || o.DeadLetter == nil {
+		return err
+	}
+	var letters []stream.DeadLetter
+	var partial *batchError
+	if errors.As(err, &partial) {
+		for idx := range partial.failed {
+			letters = append(letters, stream.NewDeadLetter(&partial.failed[idx], o.stage, err))
+		}
+	} else {
+		for idx := range batch {
+			letters = append(letters, stream.NewDeadLetter(&batch[idx].Data, o.stage, err))
+		}
+	}
+	if dlqErr := o.DeadLetter.Write(ctx, letters...); dlqErr != nil {
+		return fmt.Errorf("%w, write dead letter failed: %s", err, dlqErr)
+	}
+	return nil
+}
+
+// ackBatch 批次写入完成后确认其中的所有事件，输入端据此提交位置
+func ackBatch(batch []util.BulkItem[stream.Event], err error) {
+	for _, item := range batch {
+		item.Data.Ack.Done(err)
+	}
 }
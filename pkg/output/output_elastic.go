@@ -2,12 +2,17 @@ package output
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"go-data-flow/pkg/handler"
 	"go-data-flow/pkg/logs"
 	"go-data-flow/pkg/stream"
 	"go-data-flow/pkg/util"
+	"go-data-flow/pkg/util/jsonpath"
+	"net/http"
 	"regexp"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/olivere/elastic/v7"
@@ -16,12 +21,78 @@ import (
 )
 
 type ElasticConfig struct {
-	Url               string              `yaml:"url"`
-	User              string              `yaml:"user"`
-	Pass              string              `yaml:"pass"`
-	IndexTableMapping map[string][]string `yaml:"index_table_mapping"`
-	BulkSize          int                 `yaml:"bulk_size"`
-	BulkFlushSec      int                 `yaml:"bulk_flush_sec"`
+	Url               string                 `yaml:"url"`
+	User              string                 `yaml:"user"`
+	Pass              string                 `yaml:"pass"`
+	IndexTableMapping map[string][]string    `yaml:"index_table_mapping"`
+	BulkSize          int                    `yaml:"bulk_size"`
+	BulkFlushSec      int                    `yaml:"bulk_flush_sec"`
+	CreateConflict    string                 `yaml:"create_conflict"`  // 创建时文档已存在：ignore 忽略（默认），fail 按失败处理
+	DeleteNotFound    string                 `yaml:"delete_not_found"` // 删除时文档不存在：ignore 忽略（默认），fail 按失败处理
+	Indices           map[string]IndexConfig `yaml:"indices"`          // 按 index_table_mapping 中的索引配置写入方式
+	Mapping           ElasticMapping         `yaml:"mapping"`          // 事件数据到文档的映射
+}
+
+// index_table_mapping 的索引名可以是模板，按文档的字段生成，例如 logs-{service}-{@timestamp:2006.01.02}，
+// 文档中没有 @timestamp 时使用事件的源端时间，没有源端时间时使用当前时间
+const timestampField = "@timestamp"
+
+// IndexConfig 索引的写入方式，全量重新同步或从较早的位置开始同步时使用 index 或 upsert 可以保证幂等
+type IndexConfig struct {
+	// insert 事件的写入方式：create 文档已存在时冲突（默认），index 覆盖整个文档，upsert 合并到已有文档
+	WriteMode string `yaml:"write_mode"`
+	// update 事件在文档不存在时创建文档，write_mode 为 upsert 时总是开启
+	DocAsUpsert bool `yaml:"doc_as_upsert"`
+	// 文档 ID 模板，例如 {tenant_id}-{order_id}，默认为 {id}，未配置时没有 id 字段的 insert 由 ES 生成 ID
+	IDTemplate string `yaml:"id_template"`
+	// 外部版本号，ES 拒绝版本号不大于已有文档的写入，避免重试或重新同步时旧数据覆盖新数据。
+	// position 使用 canal 的 binlog 位置，其他值为文档中的字段，例如 updated_at，支持整数和时间。
+	// 开启后 insert 和 update 都覆盖整个文档，不能与 upsert 同时使用
+	Version string `yaml:"version"`
+	// 索引名作为滚动别名写入，首次写入时别名不存在则创建 <别名>-000001 作为写索引，
+	// 由 ILM 或 rollover 接口滚动。update 和 delete 只作用于当前的写索引
+	RolloverAlias bool `yaml:"rollover_alias"`
+	// 首次写入前创建索引模板，已存在的同名模板会被覆盖
+	IndexTemplate *IndexTemplateConfig `yaml:"index_template"`
+}
+
+// IndexTemplateConfig 可组合索引模板
+type IndexTemplateConfig struct {
+	Name     string                 `yaml:"name"`           // 模板名，默认为索引名去掉字段部分
+	Patterns []string               `yaml:"index_patterns"` // 默认为索引名中字段替换为 *，滚动别名时再加上 -*
+	Priority int                    `yaml:"priority"`
+	Settings map[string]interface{} `yaml:"settings"`
+	Mappings map[string]interface{} `yaml:"mappings"`
+}
+
+const (
+	WriteModeCreate = "create"
+	WriteModeIndex  = "index"
+	WriteModeUpsert = "upsert"
+
+	VersionPosition = "position"
+)
+
+type indexWriter struct {
+	IndexConfig
+	name    *util.Template
+	id      *util.Template
+	autoID  bool            // 未配置 ID 模板，允许 ES 生成 ID
+	ready   bool            // 索引模板已创建
+	aliases map[string]bool // 已确认存在的滚动别名
+}
+
+const (
+	PolicyIgnore = "ignore"
+	PolicyFail   = "fail"
+)
+
+// esDoc 待写入的文档及其来源事件，文档写入失败时据此转入死信队列
+type esDoc struct {
+	event  *stream.Event
+	action string
+	data   map[string]interface{}
+	msg    map[string]interface{}
 }
 
 type ElasticOutput struct {
@@ -30,6 +101,8 @@ type ElasticOutput struct {
 	cfg        *ElasticConfig
 	indexregx  map[string][]*regexp.Regexp
 	indexcache map[string]string
+	writers    map[string]*indexWriter
+	mapping    *elasticMapping
 	bulk       *util.Bulk[stream.Event]
 	dataCh     chan []util.BulkItem[stream.Event]
 	logger     zerolog.Logger
@@ -63,6 +136,37 @@ func NewElasticOutput(base BaseOutput, cfg *ElasticConfig) (*ElasticOutput, erro
 		}
 
 	}
+	writers := map[string]*indexWriter{}
+	for index := range cfg.IndexTableMapping {
+		indexCfg := cfg.Indices[index]
+		switch indexCfg.WriteMode {
+		case "":
+			indexCfg.WriteMode = WriteModeCreate
+		case WriteModeCreate, WriteModeIndex, WriteModeUpsert:
+		default:
+			return nil, fmt.Errorf("index %s has unknown write mode %s", index, indexCfg.WriteMode)
+		}
+		if indexCfg.Version != "" && (indexCfg.WriteMode == WriteModeUpsert || indexCfg.DocAsUpsert) {
+			return nil, fmt.Errorf("index %s: external version can't be used with upsert", index)
+		}
+		autoID := indexCfg.IDTemplate == ""
+		if autoID {
+			indexCfg.IDTemplate = "{id}"
+		}
+		name, err := util.ParseTemplate(index)
+		if err != nil {
+			return nil, err
+		}
+		id, err := util.ParseTemplate(indexCfg.IDTemplate)
+		if err != nil {
+			return nil, err
+		}
+		writers[index] = &indexWriter{IndexConfig: indexCfg, name: name, id: id, autoID: autoID, aliases: map[string]bool{}}
+	}
+	mapping, err := newElasticMapping(cfg.Mapping)
+	if err != nil {
+		return nil, err
+	}
 	dataCh := make(chan []util.BulkItem[stream.Event])
 	bulk := util.NewBulk(cfg.BulkSize, time.Duration(cfg.BulkFlushSec)*time.Second, dataCh)
 
@@ -72,6 +176,8 @@ func NewElasticOutput(base BaseOutput, cfg *ElasticConfig) (*ElasticOutput, erro
 		cfg:        cfg,
 		indexregx:  regxmap,
 		indexcache: map[string]string{},
+		writers:    writers,
+		mapping:    mapping,
 		bulk:       bulk,
 		dataCh:     dataCh,
 		logger:     baseLogger,
@@ -81,6 +187,10 @@ func NewElasticOutput(base BaseOutput, cfg *ElasticConfig) (*ElasticOutput, erro
 }
 
 func (es *ElasticOutput) OnEvent(ctx context.Context, params *stream.Event) error {
+	if err := es.waitAvailable(); err != nil {
+		return err
+	}
+	params.Ack.Add()
 	es.bulk.Add(util.BulkItem[stream.Event]{Data: *params, Type: params.Topic, Size: len(params.Datas)})
 	return nil
 }
@@ -98,9 +208,11 @@ func (es *ElasticOutput) Run() {
 				es.flushRemainingData(ctx)
 				return
 			case batch := <-es.dataCh:
-				if err := es.processBatch(ctx, batch); err != nil {
+				err := es.processBatch(ctx, batch)
+				if err != nil {
 					es.logger.Error().Err(err).Msg("failed to process batch")
 				}
+				ackBatch(batch, es.deadLetter(ctx, batch, err))
 			}
 		}
 	}()
@@ -114,104 +226,475 @@ func (es *ElasticOutput) processBatch(ctx context.Context, batch []util.BulkItem
 	if len(params) == 0 {
 		return nil
 	}
-	batchs := map[string]map[string][]map[string]interface{}{}
-	for _, param := range params {
+	// 同一索引的文档按到达顺序放在一个批量请求中，保证同一文档的 insert、update、delete 按顺序执行
+	batchs := map[string]*indexBatch{}
+	indices := []string{}
+	for pidx := range params {
+		param := &params[pidx]
 		for _, data := range param.Datas {
-			action := data["action"].(string)
-			typ := data["type"].(string)
-			msgs := []map[string]interface{}{}
-			switch data["messages"].(type) {
-			case []map[string]interface{}:
-				msgs = data["messages"].([]map[string]interface{})
-			case []interface{}:
-				for _, msg := range data["messages"].([]interface{}) {
-					msgs = append(msgs, msg.(map[string]interface{}))
+			parsed, err := es.mapping.parse(param, data)
+			if err == nil && handler.EventType(parsed.action) == handler.DDLEvent {
+				// 表结构变更不写入文档
+				continue
+			}
+			key := ""
+			if err == nil {
+				if key, err = es.indexKey(parsed.typ); err != nil {
+					err = fmt.Errorf("event %s: %w", param.Topic, err)
 				}
-			default:
-				es.logger.Error().Err(fmt.Errorf("消息类型错误")).Any("action", action).Any("type", typ).Msg("error writing batch to Elasticsearch")
 			}
-
-			index := ""
-			if index = es.indexcache[typ]; index == "" {
-				for idx, regxs := range es.indexregx {
-					for _, regx := range regxs {
-						if regx.MatchString(typ) {
-							index = idx
-							es.indexcache[typ] = index
-							break
-						}
+			if err != nil {
+				es.logger.Error().Err(err).Any("data", data).Msg("malformed event data")
+				if err = es.rejectData(ctx, param, data, err); err != nil {
+					return util.Permanent(err)
+				}
+				continue
+			}
+			writer := es.writers[key]
+			for _, msg := range parsed.docs {
+				doc := esDoc{event: param, action: parsed.action, data: data, msg: msg}
+				index, err := writer.index(doc)
+				if err != nil {
+					if err = es.rejectDoc(ctx, doc, err); err != nil {
+						return util.Permanent(err)
 					}
+					continue
 				}
-				if index == "" {
-					return fmt.Errorf("can't find elasetic index for message %s.%s", param.Topic, typ)
+				if _, ok := batchs[index]; !ok {
+					batchs[index] = &indexBatch{writer: writer}
+					indices = append(indices, index)
 				}
+				batchs[index].docs = append(batchs[index].docs, doc)
 			}
-			if _, ok := batchs[index]; !ok {
-				batchs[index] = map[string][]map[string]interface{}{}
+		}
+	}
+	// 写入批次数据，出错后不再写入，出错的和之后的文档作为未写入的文档返回
+	var failed []esDoc
+	var err error
+	for _, index := range indices {
+		batch := batchs[index]
+		if err == nil {
+			if err = es.prepareIndex(ctx, batch.writer, index); err != nil {
+				es.logger.Error().Err(err).Str("index", index).Msg("error preparing Elasticsearch index")
 			}
-			batchs[index][action] = append(batchs[index][action], msgs...)
 		}
+		if err != nil {
+			failed = append(failed, batch.docs...)
+			continue
+		}
+		var unwritten []esDoc
+		if unwritten, err = es.writeout(ctx, batch.writer, index, batch.docs); err != nil {
+			es.logger.Error().Err(err).Str("index", index).Msg("error writing batch to Elasticsearch")
+			failed = append(failed, unwritten...)
+			continue
+		}
+		es.logger.Info().Int("actions", len(batch.docs)).Str("index", index).Msg("bulk request executed successfully")
+	}
+	if err != nil {
+		return es.unwritten(failed, err)
 	}
-	// 写入批次数据
-	for index, actions := range batchs {
-		for action, messages := range actions {
-			if err := es.writeout(ctx, index, action, messages); err != nil {
-				es.logger.Error().Err(err).Str("index", index).Msg("error writing batch to Elasticsearch")
-				return err
+	return nil
+}
+
+// unwritten 未写入的文档按所属事件还原为只包含该文档的事件，只有这些事件转入死信队列
+func (es *ElasticOutput) unwritten(docs []esDoc, err error) error {
+	failed := make([]stream.Event, len(docs))
+	for idx, doc := range docs {
+		failed[idx] = *doc.event
+		failed[idx].Datas = []map[string]interface{}{es.mapping.single(doc.data, doc.msg)}
+	}
+	return &batchError{err: err, failed: failed}
+}
+
+// indexKey 按类型匹配 index_table_mapping
+func (es *ElasticOutput) indexKey(typ string) (string, error) {
+	if key := es.indexcache[typ]; key != "" {
+		return key, nil
+	}
+	for key, regxs := range es.indexregx {
+		for _, regx := range regxs {
+			if regx.MatchString(typ) {
+				es.indexcache[typ] = key
+				return key, nil
+			}
+		}
+	}
+	return "", fmt.Errorf("can't find elasetic index for %s", typ)
+}
+
+type indexBatch struct {
+	writer *indexWriter
+	docs   []esDoc
+}
+
+// index 按文档生成索引名
+func (w *indexWriter) index(doc esDoc) (string, error) {
+	if w.name.Static() {
+		return w.name.String(), nil
+	}
+	return w.name.ExecuteFunc(func(field string) interface{} {
+		value := jsonpath.Get(doc.msg, field)
+		if value == nil && field == timestampField {
+			if doc.event.Meta.Timestamp != 0 {
+				return time.Unix(doc.event.Meta.Timestamp, 0)
+			}
+			return time.Now()
+		}
+		return value
+	})
+}
+
+// prepareIndex 首次写入索引前创建索引模板和滚动别名的初始索引
+func (es *ElasticOutput) prepareIndex(ctx context.Context, writer *indexWriter, index string) error {
+	if !writer.ready && writer.IndexTemplate != nil {
+		if err := es.putIndexTemplate(ctx, writer); err != nil {
+			return err
+		}
+	}
+	writer.ready = true
+	if !writer.RolloverAlias || writer.aliases[index] {
+		return nil
+	}
+	exists, err := es.client.IndexExists(index).Do(ctx)
+	if err != nil {
+		return fmt.Errorf("check alias %s failed: %w", index, err)
+	}
+	if !exists {
+		body := map[string]interface{}{
+			"aliases": map[string]interface{}{
+				index: map[string]interface{}{"is_write_index": true},
+			},
+		}
+		_, err = es.client.CreateIndex(index + "-000001").BodyJson(body).Do(ctx)
+		// 多个实例同时创建时，以别名是否已存在为准
+		if err != nil {
+			if exists, _ = es.client.IndexExists(index).Do(ctx); !exists {
+				return fmt.Errorf("create rollover index for alias %s failed: %w", index, err)
 			}
-			es.logger.Info().Int("actions", len(messages)).Str("index", index).Msg("bulk request executed successfully")
+		} else {
+			es.logger.Info().Str("alias", index).Msg("rollover index created")
 		}
 	}
+	writer.aliases[index] = true
 	return nil
 }
 
+func (es *ElasticOutput) putIndexTemplate(ctx context.Context, writer *indexWriter) error {
+	tpl := writer.IndexTemplate
+	name := tpl.Name
+	if name == "" {
+		name = strings.Trim(strings.ReplaceAll(writer.name.Pattern(), "*", ""), "-_.")
+	}
+	patterns := tpl.Patterns
+	if len(patterns) == 0 {
+		pattern := writer.name.Pattern()
+		if writer.RolloverAlias {
+			pattern += "-*"
+		}
+		patterns = []string{pattern}
+	}
+	template := map[string]interface{}{}
+	if len(tpl.Settings) > 0 {
+		template["settings"] = jsonValue(tpl.Settings)
+	}
+	if len(tpl.Mappings) > 0 {
+		template["mappings"] = jsonValue(tpl.Mappings)
+	}
+	body := map[string]interface{}{
+		"index_patterns": patterns,
+		"priority":       tpl.Priority,
+		"template":       template,
+	}
+	if _, err := es.client.IndexPutIndexTemplate(name).BodyJson(body).Do(ctx); err != nil {
+		return fmt.Errorf("put index template %s failed: %w", name, err)
+	}
+	es.logger.Info().Str("template", name).Strs("patterns", patterns).Msg("index template created")
+	return nil
+}
+
+// jsonValue yaml 解析出的 map[interface{}]interface{} 转换为可以 JSON 序列化的 map[string]interface{}
+func jsonValue(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[interface{}]interface{}:
+		out := make(map[string]interface{}, len(v))
+		for key, item := range v {
+			out[fmt.Sprint(key)] = jsonValue(item)
+		}
+		return out
+	case map[string]interface{}:
+		out := make(map[string]interface{}, len(v))
+		for key, item := range v {
+			out[key] = jsonValue(item)
+		}
+		return out
+	case []interface{}:
+		out := make([]interface{}, len(v))
+		for idx, item := range v {
+			out[idx] = jsonValue(item)
+		}
+		return out
+	default:
+		return v
+	}
+}
+
 func (es *ElasticOutput) flushRemainingData(ctx context.Context) {
 	es.logger.Info().Msg("elasticOutput flush remaining data")
 	select {
 	case remainingBatch := <-es.dataCh:
-		if err := es.processBatch(ctx, remainingBatch); err != nil {
+		err := es.processBatch(ctx, remainingBatch)
+		if err != nil {
 			es.logger.Error().Err(err).Msg("error flushing remaining data to elasticsearch")
 		}
+		ackBatch(remainingBatch, es.deadLetter(ctx, remainingBatch, err))
 	default:
 		// 无剩余数据
 	}
 	es.logger.Info().Msg("elasticOutput flush remaining data end")
 }
 
-func (es *ElasticOutput) writeout(ctx context.Context, index, action string, msgs []map[string]interface{}) error {
-	if len(msgs) == 0 {
+// writeout 批量写入文档，429 和 5xx 的文档按重试策略重试，其他失败的文档转入死信队列。
+// 重试后仍失败时返回最后一次请求中未写入的文档
+func (es *ElasticOutput) writeout(ctx context.Context, writer *indexWriter, index string, docs []esDoc) ([]esDoc, error) {
+	if len(docs) == 0 {
+		return nil, nil
+	}
+	reqs := make([]elastic.BulkableRequest, 0, len(docs))
+	valid := make([]esDoc, 0, len(docs))
+	for _, doc := range docs {
+		req, err := es.request(writer, index, doc)
+		if err != nil {
+			// 缺少 ID、路由或版本字段的文档无法写入
+			if err = es.rejectDoc(ctx, doc, err); err != nil {
+				return docs, util.Permanent(err)
+			}
+			continue
+		}
+		reqs = append(reqs, req)
+		valid = append(valid, doc)
+	}
+	docs = valid
+
+	pending := make([]int, len(docs))
+	for idx := range pending {
+		pending[idx] = idx
+	}
+	err := es.writeBatch(func() error {
+		bulkRequest := es.client.Bulk()
+		for _, idx := range pending {
+			bulkRequest.Add(reqs[idx])
+		}
+		resp, err := bulkRequest.Do(ctx)
+		if err != nil {
+			return fmt.Errorf("failed to execute bulk request: %w", err)
+		}
+		var retry []int
+		for i, item := range resp.Items {
+			if i >= len(pending) {
+				break
+			}
+			idx := pending[i]
+			for op, result := range item {
+				switch es.itemResult(writer, op, result) {
+				case itemRetry:
+					retry = append(retry, idx)
+				case itemFailed:
+					if err := es.documentFailed(ctx, index, docs[idx], op, result); err != nil {
+						return util.Permanent(err)
+					}
+				}
+			}
+		}
+		if len(retry) > 0 {
+			pending = retry
+			return fmt.Errorf("%d documents of index %s failed: %w", len(retry), index, errRetryableItems)
+		}
 		return nil
+	})
+	if err != nil {
+		unwritten := make([]esDoc, len(pending))
+		for i, idx := range pending {
+			unwritten[i] = docs[idx]
+		}
+		return unwritten, err
 	}
-	bulkRequest := es.client.Bulk()
+	return nil, nil
+}
 
-	for _, msg := range msgs {
-		if msg["id"] == nil {
-			return fmt.Errorf("%s has no id filed", index)
+func (es *ElasticOutput) request(writer *indexWriter, index string, doc esDoc) (elastic.BulkableRequest, error) {
+	routing := ""
+	if es.mapping.routing != nil {
+		var err error
+		if routing, err = es.mapping.routing.Execute(doc.msg); err != nil {
+			return nil, fmt.Errorf("index %s routing: %w", index, err)
 		}
-		id := fmt.Sprintf("%v", msg["id"]) // 如果没有ID，需要rename或者combine出唯一ID字段
-		var req elastic.BulkableRequest
+	}
+	return writer.request(index, doc.action, routing, doc)
+}
 
-		switch handler.EventType(action) {
-		case handler.InsertEvent:
-			req = elastic.NewBulkCreateRequest().Index(index).Id(id).Doc(msg)
-		case handler.UpdateEvent:
-			req = elastic.NewBulkUpdateRequest().Index(index).Id(id).Doc(msg)
-		case handler.DeleteEvent:
-			req = elastic.NewBulkDeleteRequest().Index(index).Id(id)
+// request 按索引的写入方式生成批量请求
+func (w *indexWriter) request(index, action, routing string, doc esDoc) (elastic.BulkableRequest, error) {
+	id, err := w.id.Execute(doc.msg)
+	if err != nil {
+		autoID := w.autoID && w.Version == "" && w.WriteMode != WriteModeUpsert && handler.EventType(action) == handler.InsertEvent
+		if !autoID {
+			// 如果没有ID，需要rename或者combine出唯一ID字段
+			return nil, util.Permanent(fmt.Errorf("index %s: %w", index, err))
+		}
+		id = ""
+	}
+	if w.Version != "" {
+		return w.versionedRequest(index, action, id, routing, doc)
+	}
+	msg := doc.msg
+	switch handler.EventType(action) {
+	case handler.InsertEvent:
+		switch w.WriteMode {
+		case WriteModeIndex:
+			return elastic.NewBulkIndexRequest().Index(index).Id(id).Routing(routing).Doc(msg), nil
+		case WriteModeUpsert:
+			return elastic.NewBulkUpdateRequest().Index(index).Id(id).Routing(routing).Doc(msg).DocAsUpsert(true), nil
 		default:
-			return fmt.Errorf("unsupported event type: %v", action)
+			return elastic.NewBulkCreateRequest().Index(index).Id(id).Routing(routing).Doc(msg), nil
 		}
-		bulkRequest = bulkRequest.Add(req)
+	case handler.UpdateEvent:
+		if w.WriteMode == WriteModeIndex {
+			return elastic.NewBulkIndexRequest().Index(index).Id(id).Routing(routing).Doc(msg), nil
+		}
+		upsert := w.DocAsUpsert || w.WriteMode == WriteModeUpsert
+		return elastic.NewBulkUpdateRequest().Index(index).Id(id).Routing(routing).Doc(msg).DocAsUpsert(upsert), nil
+	case handler.DeleteEvent:
+		return elastic.NewBulkDeleteRequest().Index(index).Id(id).Routing(routing), nil
+	default:
+		return nil, util.Permanent(fmt.Errorf("unsupported event type: %v", action))
+	}
+}
 
+// versionedRequest ES 的 create 和 update 不支持外部版本号，insert 和 update 都使用 index
+func (w *indexWriter) versionedRequest(index, action, id, routing string, doc esDoc) (elastic.BulkableRequest, error) {
+	version, err := w.version(doc)
+	if err != nil {
+		return nil, util.Permanent(fmt.Errorf("index %s document %s: %w", index, id, err))
+	}
+	switch handler.EventType(action) {
+	case handler.InsertEvent, handler.UpdateEvent:
+		return elastic.NewBulkIndexRequest().Index(index).Id(id).Routing(routing).Doc(doc.msg).
+			VersionType("external").Version(version), nil
+	case handler.DeleteEvent:
+		return elastic.NewBulkDeleteRequest().Index(index).Id(id).Routing(routing).
+			VersionType("external").Version(version), nil
+	default:
+		return nil, util.Permanent(fmt.Errorf("unsupported event type: %v", action))
 	}
+}
 
-	if bulkRequest.NumberOfActions() == 0 {
-		return nil
+func (w *indexWriter) version(doc esDoc) (int64, error) {
+	if w.Version == VersionPosition {
+		return positionVersion(doc.event.Meta.Position)
 	}
+	value := jsonpath.Get(doc.msg, w.Version)
+	if value == nil {
+		return 0, fmt.Errorf("version field %s not found", w.Version)
+	}
+	return valueVersion(value)
+}
 
-	_, err := bulkRequest.Do(ctx)
+// positionVersion binlog 文件序号作为高 32 位，文件内位置作为低 32 位，保证随位置单调递增
+func positionVersion(position string) (int64, error) {
+	sep := strings.LastIndexByte(position, ':')
+	if sep < 0 {
+		return 0, fmt.Errorf("event has no binlog position")
+	}
+	file, pos := position[:sep], position[sep+1:]
+	seq, err := strconv.ParseUint(file[strings.LastIndexByte(file, '.')+1:], 10, 31)
 	if err != nil {
-		return fmt.Errorf("failed to execute bulk request: %w", err)
+		return 0, fmt.Errorf("invalid binlog file %s", file)
 	}
-	return nil
+	offset, err := strconv.ParseUint(pos, 10, 32)
+	if err != nil {
+		return 0, fmt.Errorf("invalid binlog position %s", position)
+	}
+	return int64(seq<<32 | offset), nil
+}
+
+// valueVersion 整数直接作为版本号，时间转换为微秒时间戳
+func valueVersion(value interface{}) (int64, error) {
+	switch v := value.(type) {
+	case int:
+		return int64(v), nil
+	case int32:
+		return int64(v), nil
+	case int64:
+		return v, nil
+	case uint32:
+		return int64(v), nil
+	case uint64:
+		return int64(v), nil
+	case float64:
+		return int64(v), nil
+	case []byte:
+		return valueVersion(string(v))
+	case string:
+		if version, err := strconv.ParseInt(v, 10, 64); err == nil {
+			return version, nil
+		}
+	}
+	if t, ok := util.ToTime(value); ok {
+		return t.UnixMicro(), nil
+	}
+	return 0, fmt.Errorf("unsupported version value %v", value)
+}
+
+type itemState int
+
+const (
+	itemOK itemState = iota
+	itemRetry
+	itemFailed
+)
+
+// itemResult 按返回状态判断单个文档的写入结果
+func (es *ElasticOutput) itemResult(writer *indexWriter, op string, result *elastic.BulkResponseItem) itemState {
+	status := result.Status
+	switch {
+	case status >= 200 && status < 300:
+		return itemOK
+	case status == http.StatusConflict && writer.Version != "":
+		// 版本号不大于已有文档，是重试或重新同步的旧数据
+		es.logger.Debug().Str("index", result.Index).Str("id", result.Id).Int64("version", result.Version).Msg("stale document skipped")
+		return itemOK
+	case status == http.StatusConflict && op == "create" && es.cfg.CreateConflict != PolicyFail:
+		return itemOK
+	case status == http.StatusNotFound && op == "delete" && es.cfg.DeleteNotFound != PolicyFail:
+		return itemOK
+	case status == http.StatusTooManyRequests || status >= 500:
+		return itemRetry
+	default:
+		return itemFailed
+	}
+}
+
+// documentFailed 无法写入的文档转入死信队列，未配置死信队列时记录错误后跳过
+func (es *ElasticOutput) documentFailed(ctx context.Context, index string, doc esDoc, op string, result *elastic.BulkResponseItem) error {
+	reason := fmt.Sprintf("%s %s/%s status %d", op, index, result.Id, result.Status)
+	if result.Error != nil {
+		reason = fmt.Sprintf("%s: %s %s", reason, result.Error.Type, result.Error.Reason)
+	}
+	return es.rejectDoc(ctx, doc, errors.New(reason))
+}
+
+// rejectDoc 只保留失败的文档，重放时不重复写入同一事件中的其他文档
+func (es *ElasticOutput) rejectDoc(ctx context.Context, doc esDoc, err error) error {
+	es.logger.Error().Err(err).Any("doc", doc.msg).Msg("document rejected")
+	return es.rejectData(ctx, doc.event, es.mapping.single(doc.data, doc.msg), err)
+}
+
+// rejectData 格式错误或无法写入的数据转入死信队列，未配置死信队列时跳过
+func (es *ElasticOutput) rejectData(ctx context.Context, event *stream.Event, data map[string]interface{}, err error) error {
+	if es.DeadLetter == nil {
+		return nil
+	}
+	letter := stream.NewDeadLetter(event, es.Stage()+".document", err)
+	letter.Datas = []map[string]interface{}{data}
+	return es.DeadLetter.Write(ctx, letter)
 }
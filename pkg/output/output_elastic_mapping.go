@@ -0,0 +1,135 @@
+package output
+
+import (
+	"fmt"
+
+	"go-data-flow/pkg/handler"
+	"go-data-flow/pkg/stream"
+	"go-data-flow/pkg/util"
+)
+
+// ElasticMapping 事件数据到 ES 操作的映射，默认值对应 canal 的数据格式：
+//
+//	{"action": "insert", "table": "db.orders", "rows": [{...}, {...}]}
+//
+// 普通日志等没有 action 和 rows 字段的数据，按 default_action 写入，整条数据作为一个文档。
+// 类型字段用于匹配 index_table_mapping，没有时依次使用事件元数据中的表名和事件的 topic
+type ElasticMapping struct {
+	ActionField   string `yaml:"action_field"`   // 操作字段，值为 insert/update/delete/ddl，默认 action
+	DefaultAction string `yaml:"default_action"` // 没有操作字段时的操作，默认 insert
+	TypeField     string `yaml:"type_field"`     // 类型字段，默认 table
+	DocsField     string `yaml:"docs_field"`     // 文档字段，可以是文档或文档数组，默认 rows
+	Routing       string `yaml:"routing"`        // 路由模板，按文档字段生成，例如 {tenant_id}，默认不指定路由
+}
+
+type elasticMapping struct {
+	ElasticMapping
+	routing *util.Template
+}
+
+// esData 一条事件数据解析出的操作
+type esData struct {
+	action string
+	typ    string
+	docs   []map[string]interface{}
+}
+
+func newElasticMapping(cfg ElasticMapping) (*elasticMapping, error) {
+	if cfg.ActionField == "" {
+		cfg.ActionField = "action"
+	}
+	if cfg.DefaultAction == "" {
+		cfg.DefaultAction = string(handler.InsertEvent)
+	}
+	if cfg.TypeField == "" {
+		cfg.TypeField = "table"
+	}
+	if cfg.DocsField == "" {
+		cfg.DocsField = "rows"
+	}
+	if _, err := parseAction(cfg.DefaultAction); err != nil {
+		return nil, err
+	}
+	mapping := &elasticMapping{ElasticMapping: cfg}
+	if cfg.Routing != "" {
+		routing, err := util.ParseTemplate(cfg.Routing)
+		if err != nil {
+			return nil, err
+		}
+		mapping.routing = routing
+	}
+	return mapping, nil
+}
+
+func parseAction(action string) (string, error) {
+	switch handler.EventType(action) {
+	case handler.InsertEvent, handler.UpdateEvent, handler.DeleteEvent, handler.DDLEvent:
+		return action, nil
+	default:
+		return "", fmt.Errorf("unsupported action %s", action)
+	}
+}
+
+// parse 数据格式不符合映射时返回错误
+func (m *elasticMapping) parse(event *stream.Event, data map[string]interface{}) (esData, error) {
+	result := esData{action: m.DefaultAction}
+	if value, ok := data[m.ActionField]; ok {
+		action, ok := value.(string)
+		if !ok {
+			return result, fmt.Errorf("action field %s is %T, not string", m.ActionField, value)
+		}
+		var err error
+		if result.action, err = parseAction(action); err != nil {
+			return result, err
+		}
+	}
+
+	switch value := data[m.TypeField].(type) {
+	case string:
+		result.typ = value
+	case nil:
+		if table, ok := event.Meta.Get("table"); ok {
+			result.typ = table
+		} else {
+			result.typ = event.Topic
+		}
+	default:
+		return result, fmt.Errorf("type field %s is %T, not string", m.TypeField, value)
+	}
+
+	value, ok := data[m.DocsField]
+	if !ok {
+		result.docs = []map[string]interface{}{data}
+		return result, nil
+	}
+	switch docs := value.(type) {
+	case []map[string]interface{}:
+		result.docs = docs
+	case map[string]interface{}:
+		result.docs = []map[string]interface{}{docs}
+	case []interface{}:
+		for idx, item := range docs {
+			doc, ok := item.(map[string]interface{})
+			if !ok {
+				return result, fmt.Errorf("docs field %s item %d is %T, not object", m.DocsField, idx, item)
+			}
+			result.docs = append(result.docs, doc)
+		}
+	default:
+		return result, fmt.Errorf("docs field %s is %T, not object or array", m.DocsField, value)
+	}
+	return result, nil
+}
+
+// single 只包含一个文档的数据，文档写入失败时转入死信队列，重放时不重复写入其他文档
+func (m *elasticMapping) single(data, doc map[string]interface{}) map[string]interface{} {
+	if _, ok := data[m.DocsField]; !ok {
+		return data
+	}
+	out := make(map[string]interface{}, len(data))
+	for key, value := range data {
+		out[key] = value
+	}
+	out[m.DocsField] = []map[string]interface{}{doc}
+	return out
+}
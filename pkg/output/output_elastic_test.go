@@ -0,0 +1,213 @@
+package output
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"testing"
+
+	"go-data-flow/pkg/stream"
+	"go-data-flow/pkg/util"
+
+	"github.com/longbridgeapp/assert"
+	"github.com/olivere/elastic/v7"
+)
+
+func TestElasticItemResult(t *testing.T) {
+	es := &ElasticOutput{cfg: &ElasticConfig{}}
+	writer := &indexWriter{}
+	cases := []struct {
+		op     string
+		status int
+		state  itemState
+	}{
+		{"create", 201, itemOK},
+		{"create", 409, itemOK},
+		{"delete", 404, itemOK},
+		{"update", 404, itemFailed},
+		{"index", 400, itemFailed},
+		{"index", 429, itemRetry},
+		{"update", 503, itemRetry},
+	}
+	for _, c := range cases {
+		assert.Equal(t, c.state, es.itemResult(writer, c.op, &elastic.BulkResponseItem{Status: c.status}), c)
+	}
+
+	es.cfg.CreateConflict, es.cfg.DeleteNotFound = PolicyFail, PolicyFail
+	assert.Equal(t, itemFailed, es.itemResult(writer, "create", &elastic.BulkResponseItem{Status: 409}))
+	assert.Equal(t, itemFailed, es.itemResult(writer, "delete", &elastic.BulkResponseItem{Status: 404}))
+
+	writer.Version = VersionPosition
+	assert.Equal(t, itemOK, es.itemResult(writer, "index", &elastic.BulkResponseItem{Status: 409}))
+}
+
+func TestElasticVersion(t *testing.T) {
+	older, err := positionVersion("mysql-bin.000012:4567")
+	assert.NoError(t, err)
+	newer, err := positionVersion("mysql-bin.000013:4")
+	assert.NoError(t, err)
+	assert.True(t, newer > older)
+	_, err = positionVersion("")
+	assert.Error(t, err)
+
+	v1, err := valueVersion("2024-05-01 10:00:00.000001")
+	assert.NoError(t, err)
+	v2, err := valueVersion([]byte("2024-05-01 10:00:00.000002"))
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), v2-v1)
+	v3, err := valueVersion(int64(42))
+	assert.NoError(t, err)
+	assert.Equal(t, int64(42), v3)
+}
+
+func TestElasticIndexName(t *testing.T) {
+	name, err := util.ParseTemplate("logs-{service}-{@timestamp:2006.01.02}")
+	assert.NoError(t, err)
+	writer := &indexWriter{name: name}
+	event := &stream.Event{Meta: stream.Meta{Timestamp: 1714521600}}
+
+	index, err := writer.index(esDoc{event: event, msg: map[string]interface{}{"service": "api", "@timestamp": "2024-06-01T08:00:00Z"}})
+	assert.NoError(t, err)
+	assert.Equal(t, "logs-api-2024.06.01", index)
+	index, err = writer.index(esDoc{event: event, msg: map[string]interface{}{"service": "api"}})
+	assert.NoError(t, err)
+	assert.Equal(t, "logs-api-2024.05.01", index)
+	_, err = writer.index(esDoc{event: event, msg: map[string]interface{}{}})
+	assert.Error(t, err)
+}
+
+func TestElasticMapping(t *testing.T) {
+	mapping, err := newElasticMapping(ElasticMapping{})
+	assert.NoError(t, err)
+	event := &stream.Event{Topic: "app-logs"}
+
+	parsed, err := mapping.parse(event, map[string]interface{}{
+		"action": "update",
+		"table":  "shop.orders",
+		"rows":   []map[string]interface{}{{"id": 1}, {"id": 2}},
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, "update", parsed.action)
+	assert.Equal(t, "shop.orders", parsed.typ)
+	assert.Equal(t, 2, len(parsed.docs))
+
+	log := map[string]interface{}{"message": "hello", "level": "info"}
+	parsed, err = mapping.parse(event, log)
+	assert.NoError(t, err)
+	assert.Equal(t, "insert", parsed.action)
+	assert.Equal(t, "app-logs", parsed.typ)
+	assert.Equal(t, []map[string]interface{}{log}, parsed.docs)
+
+	_, err = mapping.parse(event, map[string]interface{}{"action": 1})
+	assert.Error(t, err)
+	_, err = mapping.parse(event, map[string]interface{}{"action": "upsert"})
+	assert.Error(t, err)
+	_, err = mapping.parse(event, map[string]interface{}{"rows": "oops"})
+	assert.Error(t, err)
+	_, err = mapping.parse(event, map[string]interface{}{"rows": []interface{}{1}})
+	assert.Error(t, err)
+
+	data := map[string]interface{}{"table": "shop.orders", "rows": []interface{}{map[string]interface{}{"id": 1}}}
+	single := mapping.single(data, map[string]interface{}{"id": 1})
+	assert.Equal(t, []map[string]interface{}{{"id": 1}}, single["rows"])
+}
+
+type memoryQueue struct {
+	letters []stream.DeadLetter
+}
+
+func (q *memoryQueue) Write(ctx context.Context, letters ...stream.DeadLetter) error {
+	q.letters = append(q.letters, letters...)
+	return nil
+}
+
+func TestElasticDeadLetterUnwritten(t *testing.T) {
+	mapping, err := newElasticMapping(ElasticMapping{})
+	assert.NoError(t, err)
+	queue := &memoryQueue{}
+	es := &ElasticOutput{BaseOutput: BaseOutput{DeadLetter: queue, stage: "output.elastic"}, mapping: mapping}
+
+	event := stream.Event{Topic: "orders", Datas: []map[string]interface{}{
+		{"rows": []interface{}{map[string]interface{}{"id": 1}, map[string]interface{}{"id": 2}}},
+	}}
+	batch := []util.BulkItem[stream.Event]{{Data: event}, {Data: stream.Event{Topic: "users"}}}
+	// 只有 id 为 2 的文档没有写入
+	doc := esDoc{event: &batch[0].Data, data: event.Datas[0], msg: map[string]interface{}{"id": 2}}
+	err = es.deadLetter(context.Background(), batch, es.unwritten([]esDoc{doc}, errors.New("unavailable")))
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(queue.letters))
+	assert.Equal(t, "orders", queue.letters[0].Topic)
+	assert.Equal(t, "output.elastic", queue.letters[0].Stage)
+	assert.Equal(t, []map[string]interface{}{{"id": 2}}, queue.letters[0].Datas[0]["rows"])
+
+	// 没有写入任何数据时整个批次转入死信队列
+	queue.letters = nil
+	assert.NoError(t, es.deadLetter(context.Background(), batch, errors.New("unavailable")))
+	assert.Equal(t, 2, len(queue.letters))
+}
+
+func TestElasticBatchOrder(t *testing.T) {
+	var ops []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// 记录批量请求中每个文档的操作顺序，文档内容行没有 _index
+		var items []map[string]map[string]interface{}
+		scanner := bufio.NewScanner(r.Body)
+		for scanner.Scan() {
+			var action map[string]map[string]interface{}
+			if json.Unmarshal(scanner.Bytes(), &action) != nil {
+				continue
+			}
+			for op, meta := range action {
+				if meta["_index"] == nil {
+					continue
+				}
+				ops = append(ops, op+":"+meta["_id"].(string))
+				items = append(items, map[string]map[string]interface{}{op: {"_id": meta["_id"], "status": 200}})
+			}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{"items": items})
+	}))
+	defer server.Close()
+
+	client, err := elastic.NewClient(elastic.SetURL(server.URL), elastic.SetSniff(false), elastic.SetHealthcheck(false))
+	assert.NoError(t, err)
+	mapping, err := newElasticMapping(ElasticMapping{})
+	assert.NoError(t, err)
+	name, err := util.ParseTemplate("orders")
+	assert.NoError(t, err)
+	id, err := util.ParseTemplate("{id}")
+	assert.NoError(t, err)
+	es := &ElasticOutput{
+		BaseOutput: BaseOutput{
+			Cancelable: util.NewCancelable(context.Background()),
+			retrier:    util.NewRetrier(util.RetryConfig{MaxAttempts: 1}, retryable(false)),
+			breaker:    util.NewBreaker(util.BreakerConfig{FailureThreshold: -1}, nil),
+			stage:      "output.elastic",
+		},
+		client:     client,
+		cfg:        &ElasticConfig{},
+		indexregx:  map[string][]*regexp.Regexp{"orders": {regexp.MustCompile("shop.orders")}},
+		indexcache: map[string]string{},
+		writers:    map[string]*indexWriter{"orders": {IndexConfig: IndexConfig{WriteMode: WriteModeCreate}, name: name, id: id, aliases: map[string]bool{}}},
+		mapping:    mapping,
+	}
+
+	// 同一批次中同一文档的 insert、update、delete 按到达顺序写入
+	event := func(action string, row map[string]interface{}) util.BulkItem[stream.Event] {
+		return util.BulkItem[stream.Event]{Data: stream.Event{Topic: "orders", Datas: []map[string]interface{}{
+			{"action": action, "table": "shop.orders", "rows": []interface{}{row}},
+		}}}
+	}
+	batch := []util.BulkItem[stream.Event]{
+		event("insert", map[string]interface{}{"id": 1, "status": "created"}),
+		event("update", map[string]interface{}{"id": 1, "status": "paid"}),
+		event("delete", map[string]interface{}{"id": 1}),
+	}
+	assert.NoError(t, es.processBatch(context.Background(), batch))
+	assert.Equal(t, []string{"create:1", "update:1", "delete:1"}, ops)
+}
@@ -0,0 +1,56 @@
+package output
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"testing"
+
+	"go-data-flow/pkg/util"
+
+	"github.com/longbridgeapp/assert"
+	"github.com/olivere/elastic/v7"
+	"github.com/segmentio/kafka-go"
+)
+
+func TestRetryable(t *testing.T) {
+	check := retryable(false)
+	assert.True(t, check(&elastic.Error{Status: 503}))
+	assert.True(t, check(&elastic.Error{Status: 429}))
+	assert.False(t, check(&elastic.Error{Status: 400}))
+	assert.True(t, check(fmt.Errorf("bulk: %w", io.ErrUnexpectedEOF)))
+	assert.True(t, check(fmt.Errorf("3 documents failed: %w", errRetryableItems)))
+	assert.True(t, check(kafka.WriteErrors{nil, kafka.LeaderNotAvailable}))
+	assert.False(t, check(kafka.WriteErrors{kafka.LeaderNotAvailable, kafka.MessageSizeTooLarge}))
+	// 无法识别的错误默认不重试
+	assert.False(t, check(errors.New("mapping failed")))
+	assert.True(t, retryable(true)(errors.New("mapping failed")))
+}
+
+func TestWriteBatchRetry(t *testing.T) {
+	output := &BaseOutput{
+		Cancelable: util.NewCancelable(context.Background()),
+		retrier:    util.NewRetrier(util.RetryConfig{MaxAttempts: 2, InitialBackoffMs: 1, MaxBackoffMs: 1}, retryable(false)),
+		breaker:    util.NewBreaker(util.BreakerConfig{FailureThreshold: -1}, nil),
+	}
+	// 熔断没有打开时也一直重试可以重试的错误，不放弃当前批次
+	attempts := 0
+	err := output.writeBatch(func() error {
+		attempts++
+		if attempts < 7 {
+			return &elastic.Error{Status: 503}
+		}
+		return nil
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, 7, attempts)
+
+	attempts = 0
+	err = output.writeBatch(func() error {
+		attempts++
+		return &elastic.Error{Status: 400}
+	})
+	assert.Error(t, err)
+	assert.Equal(t, 1, attempts)
+}
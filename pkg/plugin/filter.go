@@ -25,10 +25,7 @@ func (r Filter) OnEvent(ctx context.Context, event *stream.Event) error {
 }
 
 func (f *Filter) Match(ctx context.Context, event *stream.Event) (out *stream.Event) {
-	out = &stream.Event{
-		Context: event.Context,
-		Topic:   event.Topic,
-	}
+	out = event.WithDatas(nil)
 	hasKeyIngrex, matchd := f.BasePlugin.MatchIngrex(ctx, event)
 	if hasKeyIngrex && matchd {
 		event.Datas = event.Datas[:0]
@@ -0,0 +1,185 @@
+package stream
+
+import (
+	"context"
+	"errors"
+	"sync"
+)
+
+// Ack 记录一个事件在所有输出中的写入状态
+// 创建时由流程持有一个引用，缓冲型输出在接收事件时 Add，真正落盘后 Done，
+// 所有引用都 Done 之后事件才算确认完成
+type Ack struct {
+	mu      sync.Mutex
+	pending int
+	err     error
+	done    chan struct{}
+}
+
+func NewAck() *Ack {
+	return &Ack{pending: 1, done: make(chan struct{})}
+}
+
+func (a *Ack) Add() {
+	if a == nil {
+		return
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.pending++
+}
+
+func (a *Ack) Done(err error) {
+	if a == nil {
+		return
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.pending == 0 {
+		return
+	}
+	if err != nil && a.err == nil {
+		a.err = err
+	}
+	a.pending--
+	if a.pending == 0 {
+		close(a.done)
+	}
+}
+
+// Wait 等待事件确认完成，返回第一个写入失败的错误
+func (a *Ack) Wait(ctx context.Context) error {
+	if a == nil {
+		return nil
+	}
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-a.done:
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.err
+}
+
+type checkpoint struct {
+	acks   []*Ack
+	commit func() error
+}
+
+// Checkpointer 按事件产生的顺序等待确认，只有之前所有事件都写入成功才执行提交，
+// 用于输入端保存 binlog 位置或提交 kafka offset。
+// 一旦有事件写入失败，之后的提交全部丢弃，由输入端从最后一次成功的位置重新消费。
+// ctx 中带有 Fence 时，每次提交前都会检查租约
+type Checkpointer struct {
+	mu      sync.Mutex
+	pending []*Ack
+	queue   chan checkpoint
+	onErr   func(error)
+	failed  chan struct{} // 有事件写入失败或失去租约后关闭
+	ctx     context.Context
+	cancel  context.CancelFunc
+}
+
+// ErrCheckpointFailed 之前的事件写入失败，Checkpointer 已不再提交
+var ErrCheckpointFailed = errors.New("checkpointer failed, previous events were not written")
+
+// NewCheckpointer onErr 被调用后这个 Checkpointer 不会再提交，调用方需要丢弃它，
+// 创建新的 Checkpointer 从最后一次提交的位置重新消费，否则之后处理的事件都不会被提交
+func NewCheckpointer(ctx context.Context, onErr func(error)) *Checkpointer {
+	ctx, cancel := context.WithCancel(ctx)
+	c := &Checkpointer{
+		queue:  make(chan checkpoint, 1024),
+		onErr:  onErr,
+		failed: make(chan struct{}),
+		ctx:    ctx,
+		cancel: cancel,
+	}
+	go c.run()
+	return c
+}
+
+// Stop 停止提交，尚未执行的提交全部丢弃
+func (c *Checkpointer) Stop() {
+	c.cancel()
+}
+
+// Track 记录一个已发送到流程中的事件
+func (c *Checkpointer) Track(ack *Ack) {
+	if ack == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.pending = append(c.pending, ack)
+}
+
+// Commit 在此前 Track 的事件全部确认后执行 commit
+func (c *Checkpointer) Commit(commit func() error) {
+	c.mu.Lock()
+	acks := c.pending
+	c.pending = nil
+	c.mu.Unlock()
+	select {
+	case c.queue <- checkpoint{acks: acks, commit: commit}:
+	case <-c.ctx.Done():
+	}
+}
+
+// Drain 等待此前 Track 的事件全部确认，用于之后发送的事件不能早于之前的事件写入的情况
+func (c *Checkpointer) Drain(ctx context.Context) error {
+	drained := make(chan struct{})
+	c.Commit(func() error {
+		close(drained)
+		return nil
+	})
+	select {
+	case <-drained:
+		return nil
+	case <-c.failed:
+		return ErrCheckpointFailed
+	case <-c.ctx.Done():
+		return c.ctx.Err()
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (c *Checkpointer) run() {
+	ctx := c.ctx
+	failed := false
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case cp := <-c.queue:
+			if failed {
+				continue
+			}
+			for _, ack := range cp.acks {
+				if err := ack.Wait(ctx); err != nil {
+					if ctx.Err() != nil {
+						return
+					}
+					failed = true
+					close(c.failed)
+					c.onErr(err)
+					break
+				}
+			}
+			if failed {
+				continue
+			}
+			// 已失去租约时不再提交，之后的提交也全部丢弃
+			if err := CheckFence(ctx); err != nil {
+				failed = true
+				close(c.failed)
+				c.onErr(err)
+				continue
+			}
+			if err := cp.commit(); err != nil {
+				c.onErr(err)
+			}
+		}
+	}
+}
@@ -0,0 +1,106 @@
+package stream
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/longbridgeapp/assert"
+)
+
+func TestCheckpointerCommitAfterAck(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	committed := make(chan int, 2)
+	checkpoint := NewCheckpointer(ctx, func(err error) {})
+	first, second := NewAck(), NewAck()
+	checkpoint.Track(first)
+	checkpoint.Commit(func() error { committed <- 1; return nil })
+	checkpoint.Track(second)
+	checkpoint.Commit(func() error { committed <- 2; return nil })
+
+	// 第二个事件先确认，第一个未确认前不能提交
+	second.Done(nil)
+	first.Add()
+	first.Done(nil)
+	select {
+	case <-committed:
+		t.Fatal("commit before all outputs acked")
+	case <-time.After(50 * time.Millisecond):
+	}
+	first.Done(nil)
+	assert.Equal(t, 1, <-committed)
+	assert.Equal(t, 2, <-committed)
+}
+
+func TestCheckpointerStopOnError(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	errc := make(chan error, 1)
+	committed := make(chan int, 1)
+	checkpoint := NewCheckpointer(ctx, func(err error) { errc <- err })
+	failed, ok := NewAck(), NewAck()
+	checkpoint.Track(failed)
+	checkpoint.Commit(func() error { committed <- 1; return nil })
+	checkpoint.Track(ok)
+	checkpoint.Commit(func() error { committed <- 2; return nil })
+
+	failed.Done(errors.New("write failed"))
+	ok.Done(nil)
+	assert.Error(t, <-errc)
+	select {
+	case n := <-committed:
+		t.Fatalf("unexpected commit %d after failure", n)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+type testFence struct{ err error }
+
+func (f *testFence) Token() int64 { return 1 }
+func (f *testFence) Check() error { return f.err }
+
+func TestCheckpointerFence(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	fence := &testFence{err: errors.New("lease lost")}
+	errc := make(chan error, 1)
+	committed := make(chan int, 1)
+	checkpoint := NewCheckpointer(WithFence(ctx, fence), func(err error) { errc <- err })
+	checkpoint.Commit(func() error { committed <- 1; return nil })
+
+	assert.Equal(t, fence.err, <-errc)
+	select {
+	case <-committed:
+		t.Fatal("commit without lease")
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestCheckpointerDrain(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	checkpoint := NewCheckpointer(ctx, func(err error) {})
+	ack := NewAck()
+	checkpoint.Track(ack)
+	drained := make(chan error, 1)
+	go func() { drained <- checkpoint.Drain(ctx) }()
+	select {
+	case <-drained:
+		t.Fatal("drained before ack")
+	case <-time.After(50 * time.Millisecond):
+	}
+	ack.Done(nil)
+	assert.NoError(t, <-drained)
+
+	// 之前的事件写入失败时返回错误，不会一直等待
+	failed := NewAck()
+	checkpoint.Track(failed)
+	failed.Done(errors.New("write failed"))
+	assert.Equal(t, ErrCheckpointFailed, checkpoint.Drain(ctx))
+}
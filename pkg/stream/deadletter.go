@@ -0,0 +1,83 @@
+package stream
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// DeadLetter 处理失败的事件，写入死信队列后可以通过命令重放
+type DeadLetter struct {
+	Topic   string                   `json:",omitempty"`
+	Key     string                   `json:",omitempty"`
+	Meta    Meta                     `json:",omitempty"`
+	Datas   []map[string]interface{} `json:",omitempty"`
+	Raw     string                   `json:",omitempty"` // 输入端无法解析的原始数据
+	Format  string                   `json:",omitempty"` // 原始数据的格式，为空时按事件 JSON 解析
+	Error   string
+	Stage   string // 失败的阶段，如 input.kafka.decode、output.elastic
+	Retries int    // 已重放的次数
+	Time    time.Time
+}
+
+// DeadLetterQueue 死信队列
+type DeadLetterQueue interface {
+	Write(ctx context.Context, letters ...DeadLetter) error
+}
+
+func NewDeadLetter(event *Event, stage string, err error) DeadLetter {
+	return DeadLetter{
+		Topic:   event.Topic,
+		Key:     event.Key,
+		Meta:    event.Meta,
+		Datas:   event.Datas,
+		Error:   err.Error(),
+		Stage:   stage,
+		Retries: event.Retries,
+		Time:    time.Now(),
+	}
+}
+
+// RawDecoder 按格式解析死信中的原始数据
+type RawDecoder func(letter DeadLetter) (Event, error)
+
+var rawDecoders = map[string]RawDecoder{}
+
+// RegisterRawDecoder 注册原始数据格式的解析，由产生原始数据死信的输入注册
+func RegisterRawDecoder(format string, decoder RawDecoder) {
+	if format == "" || decoder == nil {
+		return
+	}
+	rawDecoders[format] = decoder
+}
+
+// Event 还原为重放的事件，原始数据按记录的格式解析，没有记录格式时按事件 JSON 解析
+func (d DeadLetter) Event(ctx context.Context) (Event, error) {
+	event := Event{Context: ctx, Topic: d.Topic, Key: d.Key, Meta: d.Meta, Datas: d.Datas}
+	if d.Raw != "" {
+		var err error
+		if event, err = d.decodeRaw(); err != nil {
+			return event, err
+		}
+		event.Context = ctx
+		if event.Key == "" {
+			event.Key = d.Key
+		}
+	}
+	event.Retries = d.Retries + 1
+	return event, nil
+}
+
+func (d DeadLetter) decodeRaw() (Event, error) {
+	if d.Format == "" {
+		event := Event{Topic: d.Topic, Key: d.Key, Meta: d.Meta}
+		err := json.Unmarshal([]byte(d.Raw), &event)
+		return event, err
+	}
+	decode, ok := rawDecoders[d.Format]
+	if !ok {
+		return Event{}, fmt.Errorf("unknown dead letter format %s", d.Format)
+	}
+	return decode(d)
+}
@@ -0,0 +1,36 @@
+package stream
+
+import "context"
+
+// Fence 防护令牌，提交位置前检查当前实例是否仍持有租约，
+// 防止失去 leader 身份的旧实例覆盖新 leader 保存的位置。
+// Check 只能发现本地已知的失效，暂停超过有效期的旧 leader 需要由存储比较 Token 拒绝写入
+type Fence interface {
+	Token() int64
+	Check() error
+}
+
+type fenceKey struct{}
+
+func WithFence(ctx context.Context, fence Fence) context.Context {
+	return context.WithValue(ctx, fenceKey{}, fence)
+}
+
+// CheckFence 上下文中没有防护令牌时（如未启用选主）直接通过
+func CheckFence(ctx context.Context) error {
+	fence, ok := ctx.Value(fenceKey{}).(Fence)
+	if !ok || fence == nil {
+		return nil
+	}
+	return fence.Check()
+}
+
+// FenceToken 返回上下文中的防护令牌，没有时返回 0。
+// 存储保存令牌并拒绝令牌更小的写入，租约已被其他实例取得时旧 leader 的写入不会生效
+func FenceToken(ctx context.Context) int64 {
+	fence, ok := ctx.Value(fenceKey{}).(Fence)
+	if !ok || fence == nil {
+		return 0
+	}
+	return fence.Token()
+}
@@ -2,12 +2,61 @@ package stream
 
 import (
 	"context"
+	"fmt"
+	"strconv"
 )
 
 type Event struct {
 	Context context.Context
 	Topic   string
 	Datas   []map[string]interface{}
+	Key     string // 分区键，相同键的事件按顺序处理
+	Meta    Meta
+	Retries int  `json:",omitempty"` // 从死信队列重放的次数
+	Ack     *Ack `json:"-"`
+}
+
+// Meta 事件元数据，由输入端填充，match 中可以按元数据过滤
+type Meta struct {
+	Source    string `json:",omitempty"` // 输入源，canal 为 MySQL 地址
+	Schema    string `json:",omitempty"`
+	Table     string `json:",omitempty"`
+	Action    string `json:",omitempty"`
+	Position  string `json:",omitempty"` // 源端位置，canal 为 binlog 文件:位置
+	Timestamp int64  `json:",omitempty"` // 源端提交时间，unix 秒
+	// 输入端特有的元数据，kafka 为 topic、partition、offset、key 和 header.<名称>
+	Extra map[string]string `json:",omitempty"`
+}
+
+// Get 按名称获取元数据，table 返回 schema.table 全名
+func (m Meta) Get(name string) (string, bool) {
+	switch name {
+	case "source":
+		return m.Source, m.Source != ""
+	case "schema":
+		return m.Schema, m.Schema != ""
+	case "table":
+		if m.Schema != "" && m.Table != "" {
+			return fmt.Sprintf("%s.%s", m.Schema, m.Table), true
+		}
+		return m.Table, m.Table != ""
+	case "action":
+		return m.Action, m.Action != ""
+	case "position":
+		return m.Position, m.Position != ""
+	case "timestamp":
+		return strconv.FormatInt(m.Timestamp, 10), m.Timestamp != 0
+	default:
+		value, ok := m.Extra[name]
+		return value, ok
+	}
+}
+
+// WithDatas 复制事件的上下文信息，替换数据部分
+func (e *Event) WithDatas(datas []map[string]interface{}) *Event {
+	out := *e
+	out.Datas = datas
+	return &out
 }
 
 type EventResult struct {
@@ -0,0 +1,138 @@
+package util
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// BreakerConfig 熔断配置，连续失败达到阈值后熔断，熔断期间暂停接收事件，
+// 经过 open_sec 后放行一次探测，探测成功恢复，失败继续熔断
+type BreakerConfig struct {
+	FailureThreshold int `yaml:"failure_threshold"` // 连续失败次数阈值，默认 5，小于 0 表示不熔断
+	OpenSec          int `yaml:"open_sec"`          // 熔断持续时间，默认 30 秒
+}
+
+type BreakerState int
+
+const (
+	BreakerClosed BreakerState = iota
+	BreakerOpen
+	BreakerHalfOpen
+)
+
+func (s BreakerState) String() string {
+	switch s {
+	case BreakerOpen:
+		return "open"
+	case BreakerHalfOpen:
+		return "half-open"
+	default:
+		return "closed"
+	}
+}
+
+type Breaker struct {
+	threshold int
+	openTime  time.Duration
+	onChange  func(from, to BreakerState)
+
+	mu       sync.Mutex
+	state    BreakerState
+	failures int
+	openedAt time.Time
+	closed   chan struct{} // 恢复时关闭，用于唤醒等待者
+}
+
+func NewBreaker(cfg BreakerConfig, onChange func(from, to BreakerState)) *Breaker {
+	if cfg.FailureThreshold == 0 {
+		cfg.FailureThreshold = 5
+	}
+	if cfg.OpenSec <= 0 {
+		cfg.OpenSec = 30
+	}
+	return &Breaker{
+		threshold: cfg.FailureThreshold,
+		openTime:  time.Duration(cfg.OpenSec) * time.Second,
+		onChange:  onChange,
+		closed:    make(chan struct{}),
+	}
+}
+
+func (b *Breaker) State() BreakerState {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.state
+}
+
+func (b *Breaker) Success() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.failures = 0
+	if b.state != BreakerClosed {
+		b.setState(BreakerClosed)
+		close(b.closed)
+	}
+}
+
+// Failure 记录一次失败，返回是否处于熔断状态
+func (b *Breaker) Failure() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.threshold < 0 {
+		return false
+	}
+	b.failures++
+	if b.state == BreakerHalfOpen || (b.state == BreakerClosed && b.failures >= b.threshold) {
+		if b.state == BreakerClosed {
+			b.closed = make(chan struct{})
+		}
+		b.openedAt = time.Now()
+		b.setState(BreakerOpen)
+	}
+	return b.state == BreakerOpen
+}
+
+// WaitClosed 熔断期间阻塞直到恢复，用于暂停接收事件，形成背压
+func (b *Breaker) WaitClosed(ctx context.Context) error {
+	b.mu.Lock()
+	closed, state := b.closed, b.state
+	b.mu.Unlock()
+	if state == BreakerClosed {
+		return nil
+	}
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-closed:
+		return nil
+	}
+}
+
+// WaitProbe 熔断期间等待到可以探测的时间，转为半开状态
+func (b *Breaker) WaitProbe(ctx context.Context) error {
+	b.mu.Lock()
+	wait := b.openTime - time.Since(b.openedAt)
+	b.mu.Unlock()
+	if wait > 0 {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(wait):
+		}
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.state == BreakerOpen {
+		b.setState(BreakerHalfOpen)
+	}
+	return nil
+}
+
+func (b *Breaker) setState(state BreakerState) {
+	from := b.state
+	b.state = state
+	if b.onChange != nil && from != state {
+		b.onChange(from, state)
+	}
+}
@@ -0,0 +1,43 @@
+package util
+
+import (
+	"fmt"
+	"os"
+)
+
+// FileLock 基于文件锁的单实例控制，进程退出后由系统自动释放
+type FileLock struct {
+	path string
+	file *os.File
+}
+
+func NewFileLock(path string) *FileLock {
+	return &FileLock{path: path}
+}
+
+// TryLock 尝试加锁，已被其他进程持有时返回 false
+func (l *FileLock) TryLock() (bool, error) {
+	file, err := os.OpenFile(l.path, os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return false, fmt.Errorf("open lock file %s failed: %w", l.path, err)
+	}
+	ok, err := lockFile(file)
+	if err != nil || !ok {
+		file.Close()
+		return false, err
+	}
+	file.Truncate(0)
+	fmt.Fprintf(file, "%d\n", os.Getpid())
+	l.file = file
+	return true, nil
+}
+
+func (l *FileLock) Unlock() error {
+	if l.file == nil {
+		return nil
+	}
+	err := unlockFile(l.file)
+	l.file.Close()
+	l.file = nil
+	return err
+}
@@ -0,0 +1,105 @@
+package util
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"time"
+)
+
+// RetryConfig 重试策略，按指数退避等待，每次等待时间加上随机抖动避免多个实例同时重试
+type RetryConfig struct {
+	MaxAttempts      int     `yaml:"max_attempts"`       // 最多尝试次数，默认 3，1 表示不重试
+	InitialBackoffMs int     `yaml:"initial_backoff_ms"` // 第一次重试前的等待时间，默认 200ms
+	MaxBackoffMs     int     `yaml:"max_backoff_ms"`     // 最长等待时间，默认 10s
+	Multiplier       float64 `yaml:"multiplier"`         // 每次等待时间的增长倍数，默认 2
+	Jitter           float64 `yaml:"jitter"`             // 随机抖动比例 0~1，默认 0.2
+	RetryUnknown     bool    `yaml:"retry_unknown"`      // 无法识别是否可以重试的错误也重试，默认不重试
+}
+
+type Retrier struct {
+	cfg       RetryConfig
+	retryable func(error) bool
+}
+
+// NewRetrier retryable 判断错误是否可以重试，Permanent 包装的错误总是不重试
+func NewRetrier(cfg RetryConfig, retryable func(error) bool) *Retrier {
+	if cfg.MaxAttempts <= 0 {
+		cfg.MaxAttempts = 3
+	}
+	if cfg.InitialBackoffMs <= 0 {
+		cfg.InitialBackoffMs = 200
+	}
+	if cfg.MaxBackoffMs <= 0 {
+		cfg.MaxBackoffMs = 10000
+	}
+	if cfg.Multiplier < 1 {
+		cfg.Multiplier = 2
+	}
+	if cfg.Jitter <= 0 || cfg.Jitter > 1 {
+		cfg.Jitter = 0.2
+	}
+	return &Retrier{cfg: cfg, retryable: retryable}
+}
+
+// Do 执行 fn 直到成功、遇到不可重试的错误或达到最大次数。
+// ctx 只控制重试之间的等待，取消后返回最后一次的错误
+func (r *Retrier) Do(ctx context.Context, fn func() error) error {
+	backoff := time.Duration(r.cfg.InitialBackoffMs) * time.Millisecond
+	maxBackoff := time.Duration(r.cfg.MaxBackoffMs) * time.Millisecond
+	var err error
+	for attempt := 1; ; attempt++ {
+		if err = fn(); err == nil || attempt >= r.cfg.MaxAttempts || !r.Retryable(err) {
+			return err
+		}
+		wait := time.Duration(float64(backoff) * (1 + r.cfg.Jitter*(2*rand.Float64()-1)))
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(wait):
+		}
+		backoff = min(time.Duration(float64(backoff)*r.cfg.Multiplier), maxBackoff)
+	}
+}
+
+// Pause 一轮重试全部失败后，等待最长退避时间再开始下一轮，ctx 取消时返回错误
+func (r *Retrier) Pause(ctx context.Context) error {
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-time.After(time.Duration(r.cfg.MaxBackoffMs) * time.Millisecond):
+		return nil
+	}
+}
+
+func (r *Retrier) Retryable(err error) bool {
+	if err == nil || IsPermanent(err) || errors.Is(err, context.Canceled) {
+		return false
+	}
+	return r.retryable == nil || r.retryable(err)
+}
+
+type permanentError struct {
+	err error
+}
+
+func (e *permanentError) Error() string {
+	return e.err.Error()
+}
+
+func (e *permanentError) Unwrap() error {
+	return e.err
+}
+
+// Permanent 标记重试也不会成功的错误，如配置错误、数据格式错误
+func Permanent(err error) error {
+	if err == nil {
+		return nil
+	}
+	return &permanentError{err: err}
+}
+
+func IsPermanent(err error) bool {
+	var permanent *permanentError
+	return errors.As(err, &permanent)
+}
@@ -0,0 +1,60 @@
+package util
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/longbridgeapp/assert"
+)
+
+func TestRetrier(t *testing.T) {
+	ctx := context.Background()
+	retrier := NewRetrier(RetryConfig{MaxAttempts: 3, InitialBackoffMs: 1}, nil)
+
+	attempts := 0
+	err := retrier.Do(ctx, func() error {
+		attempts++
+		if attempts < 3 {
+			return errors.New("temporary")
+		}
+		return nil
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, 3, attempts)
+
+	attempts = 0
+	err = retrier.Do(ctx, func() error {
+		attempts++
+		return Permanent(errors.New("bad request"))
+	})
+	assert.True(t, IsPermanent(err))
+	assert.Equal(t, 1, attempts)
+}
+
+func TestBreaker(t *testing.T) {
+	ctx := context.Background()
+	breaker := NewBreaker(BreakerConfig{FailureThreshold: 2, OpenSec: 1}, nil)
+	assert.False(t, breaker.Failure())
+	assert.True(t, breaker.Failure())
+	assert.Equal(t, BreakerOpen, breaker.State())
+
+	waitCtx, cancel := context.WithTimeout(ctx, 20*time.Millisecond)
+	defer cancel()
+	assert.Error(t, breaker.WaitClosed(waitCtx))
+
+	assert.NoError(t, breaker.WaitProbe(ctx))
+	assert.Equal(t, BreakerHalfOpen, breaker.State())
+	// 探测失败重新熔断
+	assert.True(t, breaker.Failure())
+
+	done := make(chan struct{})
+	go func() {
+		assert.NoError(t, breaker.WaitClosed(ctx))
+		close(done)
+	}()
+	breaker.Success()
+	<-done
+	assert.Equal(t, BreakerClosed, breaker.State())
+}
@@ -0,0 +1,114 @@
+package util
+
+import (
+	"fmt"
+	"strings"
+
+	"go-data-flow/pkg/util/jsonpath"
+)
+
+// Template 字段模板，`{field}` 替换为数据中对应字段的值，字段支持 a.b 形式的路径，
+// 例如 `{tenant_id}-{order_id}`。`{field:layout}` 将字段按时间解析后以 UTC 时间和 Go 的格式输出，
+// 例如 `logs-{@timestamp:2006.01.02}`
+type Template struct {
+	raw   string
+	parts []templatePart
+}
+
+type templatePart struct {
+	literal string
+	field   string
+	layout  string
+}
+
+func ParseTemplate(raw string) (*Template, error) {
+	t := &Template{raw: raw}
+	rest := raw
+	for len(rest) > 0 {
+		start := strings.IndexByte(rest, '{')
+		if start < 0 {
+			t.parts = append(t.parts, templatePart{literal: rest})
+			break
+		}
+		end := strings.IndexByte(rest[start:], '}')
+		if end < 0 {
+			return nil, fmt.Errorf("template %s has unclosed {", raw)
+		}
+		field := strings.TrimSpace(rest[start+1 : start+end])
+		if field == "" {
+			return nil, fmt.Errorf("template %s has empty field", raw)
+		}
+		if start > 0 {
+			t.parts = append(t.parts, templatePart{literal: rest[:start]})
+		}
+		part := templatePart{field: field}
+		if sep := strings.IndexByte(field, ':'); sep > 0 {
+			part.field, part.layout = strings.TrimSpace(field[:sep]), field[sep+1:]
+		}
+		t.parts = append(t.parts, part)
+		rest = rest[start+end+1:]
+	}
+	return t, nil
+}
+
+// Execute 字段不存在或为 null 时返回错误
+func (t *Template) Execute(data map[string]interface{}) (string, error) {
+	return t.ExecuteFunc(func(field string) interface{} {
+		return jsonpath.Get(data, field)
+	})
+}
+
+// ExecuteFunc 通过 get 获取字段的值，用于数据中没有的字段从其他地方补充
+func (t *Template) ExecuteFunc(get func(field string) interface{}) (string, error) {
+	var sb strings.Builder
+	for _, part := range t.parts {
+		if part.field == "" {
+			sb.WriteString(part.literal)
+			continue
+		}
+		value := get(part.field)
+		if value == nil {
+			return "", fmt.Errorf("field %s of template %s not found", part.field, t.raw)
+		}
+		if part.layout != "" {
+			tm, ok := ToTime(value)
+			if !ok {
+				return "", fmt.Errorf("field %s of template %s is not a time: %v", part.field, t.raw, value)
+			}
+			sb.WriteString(tm.UTC().Format(part.layout))
+			continue
+		}
+		if raw, ok := value.([]byte); ok {
+			value = string(raw)
+		}
+		fmt.Fprint(&sb, value)
+	}
+	return sb.String(), nil
+}
+
+// Static 模板中没有字段
+func (t *Template) Static() bool {
+	for _, part := range t.parts {
+		if part.field != "" {
+			return false
+		}
+	}
+	return true
+}
+
+// Pattern 字段替换为 * 的通配符形式
+func (t *Template) Pattern() string {
+	var sb strings.Builder
+	for _, part := range t.parts {
+		if part.field == "" {
+			sb.WriteString(part.literal)
+		} else {
+			sb.WriteByte('*')
+		}
+	}
+	return sb.String()
+}
+
+func (t *Template) String() string {
+	return t.raw
+}
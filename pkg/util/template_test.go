@@ -0,0 +1,36 @@
+package util
+
+import (
+	"testing"
+
+	"github.com/longbridgeapp/assert"
+)
+
+func TestTemplate(t *testing.T) {
+	tpl, err := ParseTemplate("{tenant_id}-{order.id}")
+	assert.NoError(t, err)
+	id, err := tpl.Execute(map[string]interface{}{
+		"tenant_id": int64(7),
+		"order":     map[string]interface{}{"id": []byte("a1")},
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, "7-a1", id)
+
+	_, err = tpl.Execute(map[string]interface{}{"tenant_id": 7})
+	assert.Error(t, err)
+
+	_, err = ParseTemplate("{tenant_id")
+	assert.Error(t, err)
+
+	tpl, err = ParseTemplate("logs-{service}-{@timestamp:2006.01.02}")
+	assert.NoError(t, err)
+	assert.Equal(t, "logs-*-*", tpl.Pattern())
+	name, err := tpl.Execute(map[string]interface{}{"service": "api", "@timestamp": "2024-05-01T23:00:00-02:00"})
+	assert.NoError(t, err)
+	assert.Equal(t, "logs-api-2024.05.02", name)
+	name, err = tpl.Execute(map[string]interface{}{"service": "api", "@timestamp": int64(1714521600000)})
+	assert.NoError(t, err)
+	assert.Equal(t, "logs-api-2024.05.01", name)
+	_, err = tpl.Execute(map[string]interface{}{"service": "api", "@timestamp": "yesterday"})
+	assert.Error(t, err)
+}
@@ -0,0 +1,46 @@
+package util
+
+import (
+	"strings"
+	"time"
+)
+
+var timeLayouts = []string{
+	"2006-01-02 15:04:05.999999999",
+	time.RFC3339Nano,
+	"2006-01-02",
+}
+
+// ToTime 将时间、时间字符串或 unix 时间戳（秒或毫秒）转换为时间，字符串没有时区时按本地时区解析
+func ToTime(value interface{}) (time.Time, bool) {
+	switch v := value.(type) {
+	case time.Time:
+		return v, true
+	case []byte:
+		return ToTime(string(v))
+	case string:
+		s := strings.TrimSpace(v)
+		for _, layout := range timeLayouts {
+			if t, err := time.ParseInLocation(layout, s, time.Local); err == nil {
+				return t, true
+			}
+		}
+	case int:
+		return unixTime(int64(v)), true
+	case int64:
+		return unixTime(v), true
+	case uint64:
+		return unixTime(int64(v)), true
+	case float64:
+		return unixTime(int64(v)), true
+	}
+	return time.Time{}, false
+}
+
+// unixTime 小于 1e12 的时间戳按秒处理，否则按毫秒处理
+func unixTime(ts int64) time.Time {
+	if ts < 1e12 && ts > -1e12 {
+		return time.Unix(ts, 0)
+	}
+	return time.UnixMilli(ts)
+}